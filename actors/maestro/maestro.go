@@ -44,6 +44,7 @@ type TaskSubmission struct {
 	CancelChan <-chan struct{}
 	Promise    sup.Promise
 	//Monitor struct{???}
+	Log module.LogSink // Where the module's evaluation logs go.  May be nil, which discards them.
 
 	Module       api.Module
 	Pins         funcs.Pins
@@ -142,6 +143,9 @@ func (mt maestroTask) Run(ctx sup.Context) (_ error) {
 		mt.TaskSubmission.WareSourcing,
 		mt.wareStaging,
 		repeatrclient.Run,
+		module.EvalOptions{
+			Log: mt.TaskSubmission.Log,
+		},
 	)
 	if err != nil {
 		mt.Promise.Resolve(err) // TODO should be a union type for this
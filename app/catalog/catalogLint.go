@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/hitch"
 	"go.polydawn.net/reach/gadgets/catalog"
 )
 
@@ -145,8 +147,65 @@ func (cfg Linter) Lint() error {
 					cfg.Tree.SaveModuleLineage(moduleName, *lin)
 				}
 			default:
+				if strings.HasPrefix(basename, catalog.ReplayFilePrefix) && strings.HasSuffix(basename, ".tl") {
+					relName := api.ReleaseName(basename[len(catalog.ReplayFilePrefix) : len(basename)-len(".tl")])
+					// Check parse.
+					replay, err := cfg.Tree.LoadModuleReplay(moduleName, relName)
+					if err != nil {
+						cfg.WarnBehavior(fmt.Sprintf("%v", err), func() {})
+						return nil
+					}
+
+					// Check semantic sanity.
+					// Check that the release it's for actually exists.
+					lin, err := cfg.Tree.LoadModuleLineage(moduleName)
+					if err != nil {
+						return nil // skip the rest of this check and wait for that error to be rediscovered later.
+					}
+					if _, err := hitch.LineagePluckReleaseByName(*lin, relName); err != nil {
+						cfg.WarnBehavior(
+							fmt.Sprintf("in module %q, replay found for release %q, which is not in the lineage", moduleName, relName),
+							remove(path),
+						)
+						return nil
+					}
+
+					// Rewrite, ensuring bytewise normality.
+					if cfg.Rewrite {
+						cfg.Tree.SaveModuleReplay(moduleName, relName, *replay)
+					}
+					return nil
+				}
+				if strings.HasPrefix(basename, catalog.OutputsFilePrefix) && strings.HasSuffix(basename, ".tl") {
+					relName := api.ReleaseName(basename[len(catalog.OutputsFilePrefix) : len(basename)-len(".tl")])
+					// Check parse.
+					outputs, err := cfg.Tree.LoadModuleOutputs(moduleName, relName)
+					if err != nil {
+						cfg.WarnBehavior(fmt.Sprintf("%v", err), func() {})
+						return nil
+					}
+
+					// Check semantic sanity.
+					// Check that the release it's for actually exists.
+					lin, err := cfg.Tree.LoadModuleLineage(moduleName)
+					if err != nil {
+						return nil // skip the rest of this check and wait for that error to be rediscovered later.
+					}
+					if _, err := hitch.LineagePluckReleaseByName(*lin, relName); err != nil {
+						cfg.WarnBehavior(
+							fmt.Sprintf("in module %q, replay outputs found for release %q, which is not in the lineage", moduleName, relName),
+							remove(path),
+						)
+						return nil
+					}
+
+					// Rewrite, ensuring bytewise normality.
+					if cfg.Rewrite {
+						cfg.Tree.SaveModuleOutputs(moduleName, relName, outputs)
+					}
+					return nil
+				}
 				// TODO warn about any files of names we don't know about
-				// FUTURE need cases for matching the replay prefix
 			}
 			return nil
 		case os.ModeDir:
@@ -0,0 +1,210 @@
+package catalogApp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/polydawn/go-errcat"
+	"github.com/polydawn/refmt"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/obj/atlas"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/go-timeless-api/hitch"
+	"go.polydawn.net/go-timeless-api/repeatr/client/exec"
+	"go.polydawn.net/reach/gadgets/catalog"
+	hitchGadget "go.polydawn.net/reach/gadgets/catalog/hitch"
+	"go.polydawn.net/reach/gadgets/ingest/literal"
+	"go.polydawn.net/reach/gadgets/module"
+	"go.polydawn.net/reach/gadgets/workspace"
+)
+
+type ErrorCategory string
+
+const (
+	ErrReplayMismatch = ErrorCategory("reach-replay-mismatch")
+)
+
+// ReplayReport is the result of verifying a release's replay.
+type ReplayReport struct {
+	ModuleName  api.ModuleName
+	ReleaseName api.ReleaseName
+	Items       map[api.ItemName]ReplayItemReport
+	Reproduced  bool // True iff every item matched.
+}
+
+// ReplayItemReport describes one item of a release, and what the replay produced for it.
+type ReplayItemReport struct {
+	Expected api.WareID  // As recorded in the lineage.
+	Actual   *api.WareID // As produced by the replay.  Nil if the replay didn't export this item at all.
+	Match    bool
+}
+
+var ReplayReport_AtlasEntry = atlas.BuildEntry(ReplayReport{}).StructMap().
+	SetKeyValue("module", "ModuleName").
+	SetKeyValue("release", "ReleaseName").
+	SetKeyValue("items", "Items").
+	SetKeyValue("reproduced", "Reproduced").
+	Complete()
+
+var ReplayItemReport_AtlasEntry = atlas.BuildEntry(ReplayItemReport{}).StructMap().
+	SetKeyValue("expected", "Expected").
+	SetKeyValue("actual", "Actual").
+	SetKeyValue("match", "Match").
+	Complete()
+
+var Atlas_ReplayReport = atlas.MustBuild(
+	ReplayReport_AtlasEntry,
+	ReplayItemReport_AtlasEntry,
+	api.WareID_AtlasEntry,
+)
+
+// VerifyReplay rebuilds a release from the replay instructions saved with it,
+// and checks that every item comes out with the same WareID as the lineage says.
+//
+// The report is printed to stdout as json, whether or not the release was
+// reproduced; if it wasn't, an error of category ErrReplayMismatch is also returned.
+func VerifyReplay(
+	ctx context.Context,
+	ws workspace.Workspace,
+	modName api.ModuleName,
+	relName api.ReleaseName,
+	stdout, stderr io.Writer,
+) error {
+	// Load the release and its replay.
+	//  Only the workspace catalog is consulted for these, since that's the
+	//  one catalog a release can be committed to.
+	tree := catalog.Tree{ws.Layout.CatalogRoot()}
+	lin, err := tree.LoadModuleLineage(modName)
+	if err != nil {
+		return err
+	}
+	rel, err := hitch.LineagePluckReleaseByName(*lin, relName)
+	if err != nil {
+		return err
+	}
+	replay, err := tree.LoadModuleReplay(modName, relName)
+	if err != nil {
+		return err
+	}
+	if replay == nil {
+		return errcat.ErrorDetailed(
+			hitch.ErrUsage,
+			fmt.Sprintf("release %s:%s has no replay instructions", modName, relName),
+			map[string]string{
+				"ref": string(modName) + ":" + string(relName),
+			})
+	}
+
+	// Plan the evaluation.
+	ord, err := funcs.ModuleOrderStepsDeep(*replay)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "replay of %s:%s contains %d steps\n", modName, relName, len(ord))
+
+	// Outputs are packed however they were when the release was built.
+	//  Releases with no record of that predate configuring it:
+	//  their outputs were all packed the default way.
+	outputs, err := tree.LoadModuleOutputs(modName, relName)
+	if err != nil {
+		return err
+	}
+
+	// Configure warehousing.
+	//  Wares the replay was pinned to by literal ingest could be anywhere,
+	//  so we offer the workspace warehouse and the release's own mirrors.
+	wareStaging := ws.WareStaging()
+	wareSourcing := ws.StagingWareSourcing()
+	for _, pth := range ws.StagingWarehousePaths() {
+		os.Mkdir(pth, 0755)
+	}
+	if mirrors, err := tree.LoadModuleMirrors(modName); err != nil {
+		return err
+	} else if mirrors != nil {
+		wareSourcing.Append(*mirrors)
+	}
+
+	// Pin the imports.
+	//  Replays should only ever contain catalog imports and literal ingests;
+	//  anything else would not be a replay, so we refuse it.
+	viewLineageTool, viewWarehousesTool := hitchGadget.ViewTools(ws.CatalogTrees()...)
+	resolveTool := func(ctx context.Context, ingestRef api.ImportRef_Ingest) (*api.WareID, *api.WareSourcing, error) {
+		if ingestRef.IngestKind != "literal" {
+			return nil, nil, fmt.Errorf("replay contains non-literal ingest %q", ingestRef)
+		}
+		return literalingest.Resolve(ctx, ingestRef)
+	}
+	pins, pinWs, err := funcs.ResolvePins(*replay, viewLineageTool, viewWarehousesTool, resolveTool)
+	if err != nil {
+		return errcat.Errorf(
+			"reach-resolve-imports-failed",
+			"cannot resolve imports: %s", err)
+	}
+	wareSourcing.Append(*pinWs)
+
+	// Memoization would defeat the purpose of checking.
+	//  Make sure it's off, even if the environment had it on.
+	os.Unsetenv("REPEATR_MEMODIR")
+
+	// Evaluate!
+	exports, err := module.Evaluate(
+		ctx,
+		*replay,
+		ord,
+		pins,
+		wareSourcing,
+		wareStaging,
+		repeatrclient.Run,
+		module.EvalOptions{
+			Log:        module.NewLogSink(stderr),
+			OutputSpec: outputs.OutputSpec,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("evaluating replay: %s", err)
+	}
+
+	// Compare, and report.
+	report := ReplayReport{
+		ModuleName:  modName,
+		ReleaseName: relName,
+		Items:       make(map[api.ItemName]ReplayItemReport, len(rel.Items)),
+		Reproduced:  true,
+	}
+	mismatched := []string{}
+	for itemName, expected := range rel.Items {
+		item := ReplayItemReport{Expected: expected}
+		if actual, ok := exports[itemName]; ok {
+			item.Actual = &actual
+			item.Match = actual == expected
+		}
+		if !item.Match {
+			report.Reproduced = false
+			mismatched = append(mismatched, string(itemName))
+		}
+		report.Items[itemName] = item
+	}
+	if err := refmt.NewMarshallerAtlased(
+		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
+		stdout,
+		Atlas_ReplayReport,
+	).Marshal(report); err != nil {
+		panic(err)
+	}
+	if !report.Reproduced {
+		sort.Strings(mismatched)
+		return errcat.ErrorDetailed(
+			ErrReplayMismatch,
+			fmt.Sprintf("replay of %s:%s did not reproduce %d of %d items: %v", modName, relName, len(mismatched), len(rel.Items), mismatched),
+			map[string]string{
+				"ref": string(modName) + ":" + string(relName),
+			})
+	}
+	fmt.Fprintf(stderr, "replay of %s:%s reproduced all %d items\n", modName, relName, len(rel.Items))
+	return nil
+}
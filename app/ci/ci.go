@@ -14,6 +14,7 @@ import (
 )
 
 func Loop(
+	ctx context.Context, // cancelling this ends the loop (and interrupts any evaluation in progress).
 	workspace workspace.Workspace, // ... shouldn't actually be needed, really.
 	landmarks layout.Module, // needed in case of ingests with relative paths.
 	mod api.Module, // already helpfully loaded for us.
@@ -40,16 +41,20 @@ func Loop(
 	previouslyIngested := api.WareID{}
 	for {
 		gitResolve := gitingest.Config{landmarks.ModuleRoot()}.Resolve
-		newlyIngested, _, err := gitResolve(context.Background(), hingeIngest)
+		newlyIngested, _, err := gitResolve(ctx, hingeIngest)
 		if err != nil {
 			return err
 		}
 		if *newlyIngested == previouslyIngested {
-			time.Sleep(1260 * time.Millisecond)
-			continue
+			select {
+			case <-time.After(1260 * time.Millisecond):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 		}
 		fmt.Fprintf(stderr, "found new git hash!  evaluating %s\n", newlyIngested)
-		if err := emergeApp.EvalModule(workspace, landmarks, nil, mod, stdout, stderr); err != nil {
+		if err := emergeApp.EvalModule(ctx, workspace, landmarks, nil, mod, emergeApp.Options{}, stdout, stderr); err != nil {
 			return err
 		}
 		fmt.Fprintf(stderr, "CI execution done, successfully.  Going into standby until more changes.\n")
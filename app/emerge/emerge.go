@@ -5,16 +5,15 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"sort"
+	"strings"
+	"time"
 
-	"github.com/polydawn/refmt"
-	"github.com/polydawn/refmt/json"
-	"github.com/polydawn/refmt/obj/atlas"
 	"github.com/warpfork/go-errcat"
 
 	"go.polydawn.net/go-timeless-api"
 	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/go-timeless-api/hitch"
+	"go.polydawn.net/go-timeless-api/repeatr"
 	"go.polydawn.net/go-timeless-api/repeatr/client/exec"
 	"go.polydawn.net/reach/gadgets/catalog"
 	hitchGadget "go.polydawn.net/reach/gadgets/catalog/hitch"
@@ -24,26 +23,320 @@ import (
 	"go.polydawn.net/reach/gadgets/workspace"
 )
 
+type ErrorCategory string
+
+const (
+	// The module was evaluated as far as possible (see Options.KeepGoing),
+	// but some steps failed.
+	ErrJobsFailed = ErrorCategory("reach-jobs-failed")
+
+	// Options.Resume was set, but there's nothing (valid) to resume.
+	ErrCannotResume = ErrorCategory("reach-cannot-resume")
+)
+
+// Options holds the optional parameters for evaluating modules.
+// The zero value is valid.
+type Options struct {
+	// Maximum number of steps to run at once.  Zero means one.
+	Parallelism int
+
+	// Either LogFormatText (the default) or LogFormatJson.
+	LogFormat string
+
+	// If true, resolve every step's formula and report them (and which
+	// would be memo hits), but don't run anything or save any candidates.
+	Plan bool
+
+	// If either is set, only evaluate these steps and the steps producing
+	// these exports, and whatever they depend on (see module.PruneOrder).
+	// Only part of the module is evaluated, so no candidate is saved.
+	TargetSteps   []api.SubmoduleStepRef
+	TargetExports []api.ItemName
+
+	// Replace the pins of these imports (see PinOverride).
+	// The module isn't built against what it says it imports, so no candidate is saved.
+	PinOverrides []PinOverride
+
+	// If true, keep evaluating steps which don't depend on failed steps,
+	// then summarize the failures (see module.EvalOptions.KeepGoing).
+	// If anything failed, no candidate is saved.
+	KeepGoing bool
+
+	// If true, resume the last evaluation of this module, which must have
+	// failed: steps which completed then aren't evaluated again, and their
+	// results are reused.  The module, its pins, and how its outputs are
+	// packed must be unchanged.
+	Resume bool
+
+	// Time limits for the whole evaluation, and for each operation in it.
+	// Zero means to use the workspace config (which defaults to no limit).
+	Timeout     time.Duration
+	StepTimeout time.Duration
+}
+
+func (opts Options) pruned() bool {
+	return len(opts.TargetSteps) > 0 || len(opts.TargetExports) > 0
+}
+
+// checkOutputOverrides checks that each output named (as in
+// workspace.Config.ModuleOutputs) is an output of an operation in the module.
+func checkOutputOverrides(mod api.Module, outputs []string) error {
+	for _, output := range outputs {
+		i := strings.LastIndex(output, ".")
+		if i < 0 {
+			return errcat.Errorf(hitch.ErrUsage, "workspace config moduleOutputs: %q should be of the form step.slot", output)
+		}
+		step, err := module.LookupStep(mod, module.ParseStepRef(output[:i]))
+		if err != nil {
+			return errcat.Errorf(hitch.ErrUsage, "workspace config moduleOutputs: %q: %s", output, err)
+		}
+		op, ok := step.(api.Operation)
+		if !ok {
+			return errcat.Errorf(hitch.ErrUsage, "workspace config moduleOutputs: %q: %q is a submodule, not an operation", output, output[:i])
+		}
+		if _, ok := op.Outputs[api.SlotName(output[i+1:])]; !ok {
+			return errcat.Errorf(hitch.ErrUsage, "workspace config moduleOutputs: %q: operation %q has no output %q", output, output[:i], output[i+1:])
+		}
+	}
+	return nil
+}
+
 func EvalModule(
+	ctx context.Context, // cancelling this interrupts the evaluation.
 	ws workspace.Workspace, // needed to figure out if we have a moduleName.
 	lm layout.Module, // needed in case of ingests with relative paths.
 	sagaName *catalog.SagaName, // may have been provided as a flag.
 	mod api.Module, // already helpfully loaded for us.
+	opts Options,
+	stdout, stderr io.Writer,
+) error {
+	// If we're going to save a candidate release, figure out the module name now;
+	//  there's no sense discovering the workspace can't name this module only
+	//  after we've done all the work of evaluating it.
+	//  (If we're not, the name is still nice to have for the json log,
+	//   but it's fine if there isn't one.)
+	modName, err := ws.ResolveModuleName(lm)
+	if err != nil && sagaName != nil {
+		return err
+	}
+
+	// Set up progress reporting.
+	prog, err := newProgress(opts.LogFormat, modName, stdout, stderr)
+	if err != nil {
+		return err
+	}
+
+	// Apply the time limits.
+	//  The whole-run limit goes on the context; the step limit is Evaluate's business.
+	if opts.Timeout == 0 {
+		opts.Timeout = ws.Timeout()
+	}
+	if opts.StepTimeout == 0 {
+		opts.StepTimeout = ws.StepTimeout()
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	// Work out everything the evaluation needs, short of running it.
+	ev, err := prepare(ctx, ws, lm, sagaName, modName, mod, opts, prog)
+	if err != nil {
+		return err
+	}
+
+	// In plan mode, evaluate with a stand-in for repeatr, and report what would happen.
+	if opts.Plan {
+		p, err := plan(ctx, ws, mod, ev, opts.pruned())
+		if err != nil {
+			return err
+		}
+		p.report(stdout, stderr)
+		return nil
+	}
+
+	// Ensure memoization is enabled.
+	//  Future: this is a bit of an odd reach-around way to configure this.
+	//  PRs which propose more/better ways to enable and parameterize memoization would be extremely welcomed.
+	os.Setenv("REPEATR_MEMODIR", ws.MemoDir())
+	os.Mkdir(ws.MemoDir(), 0755) // Errors ignored.  Repeatr will emit warns, but work.
+
+	// Set up the state that lets a failed evaluation be resumed.
+	//  (Or, if we're resuming, load it.)
+	res, err := newResumer(ws.Layout, modName, mod, ev.pins, ev.outputSpec, opts.Resume, stderr)
+	if err != nil {
+		return err
+	}
+
+	// Begin the evaluation!
+	//  Everything that happens is also collected for the run report.
+	completed := res.completed()
+	rep := newReporter(modName, ev.pins)
+	rep.reused(completed, ev.ord)
+	exports, err := module.Evaluate(
+		ctx,
+		mod,
+		ev.ord,
+		ev.pins,
+		ev.wareSourcing,
+		ev.wareStaging,
+		repeatrclient.Run,
+		module.EvalOptions{
+			Parallelism: opts.Parallelism,
+			Log:         prog.logSink(),
+			Events:      module.TeeEventSink(prog, rep, res),
+			KeepGoing:   opts.KeepGoing,
+			Pruned:      opts.pruned(),
+			Completed:   completed,
+			StepTimeout: opts.StepTimeout,
+			OutputSpec:  ev.outputSpec,
+		},
+	)
+	if err == nil {
+		if err := res.clear(); err != nil {
+			return err
+		}
+	}
+	rep.finish(exports, err)
+	// Failing to save the report loses only the report; say so,
+	//  but the outcome of the evaluation is still what decides ours.
+	if _, err := rep.save(ws.Layout); err != nil {
+		fmt.Fprintf(stderr, "warning: %s\n", err)
+	}
+	if failures, ok := err.(*module.JobFailures); ok {
+		prog.exported(exports)
+		prog.failed(failures)
+		return errcat.Errorf(ErrJobsFailed, "evaluating module: %s", failures)
+	}
+	if err != nil && ctx.Err() != nil {
+		reason := "cancelled"
+		if ctx.Err() == context.DeadlineExceeded {
+			reason = fmt.Sprintf("timed out after %s", opts.Timeout)
+		}
+		rep.summarizeInterruption(stderr, mod, ev.ord, reason)
+		if modName != "" {
+			fmt.Fprintf(stderr, "steps which completed can be reused by resuming the evaluation (with --resume).\n")
+		}
+		return fmt.Errorf("evaluating module: %s", reason)
+	}
+	if err != nil {
+		return fmt.Errorf("evaluating module: %s", err)
+	}
+	prog.exported(exports)
+
+	// Save a "candidate" release!
+	// (Unless there's no saga name, that must've been on purpose.)
+	// (Or unless we only evaluated part of the module: that's no release.)
+	if sagaName == nil {
+		return nil
+	}
+	if opts.pruned() {
+		fmt.Fprintf(stderr, "not saving a candidate release: only part of the module was evaluated\n")
+		return nil
+	}
+	if len(ev.overridden) > 0 {
+		fmt.Fprintf(stderr, "not saving a candidate release: some imports were overridden\n")
+		return nil
+	}
+	//  The candidate records the module with replaced imports rewritten,
+	//  since that's what it was really built from.
+	effectiveMod := hitchGadget.ReplaceImports(mod, ws.Replacements())
+	if err := catalog.SaveCandidateRelease(ws.Layout, *sagaName, modName, effectiveMod, ev.pins, exports, stderr); err != nil {
+		return err
+	}
+	outputs := replayOutputs(mod, ev.ord, ev.outputSpec)
+	if err := catalog.SaveCandidateReplay(ws.Layout, *sagaName, modName, effectiveMod, ev.pins, outputs, exports, stderr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ExportFormulas resolves every step's formula, as EvalModule does in
+// plan mode, and writes each one, with its formula context, into dir,
+// as files repeatr can run directly.  The files written are listed on stdout.
+func ExportFormulas(
+	ctx context.Context,
+	ws workspace.Workspace,
+	lm layout.Module,
+	sagaName *catalog.SagaName, // may have been provided as a flag.
+	mod api.Module,
+	dir string,
 	stdout, stderr io.Writer,
 ) error {
+	// The module name is only needed to find the workspace's output config
+	//  for the module; if it has no name, it gets the defaults.
+	modName, _ := ws.ResolveModuleName(lm)
+	prog := textProgress{stdout, stderr}
+	ev, err := prepare(ctx, ws, lm, sagaName, modName, mod, Options{}, prog)
+	if err != nil {
+		return err
+	}
+	p, err := plan(ctx, ws, mod, ev, false)
+	if err != nil {
+		return err
+	}
+	return p.export(dir, stdout, stderr)
+}
+
+// replayOutputs records how every output of every operation in the order
+// is packed, so the replay can be evaluated the same way.
+func replayOutputs(
+	mod api.Module,
+	ord []api.SubmoduleStepRef,
+	outputSpec func(api.SubmoduleStepRef, api.SlotName) api.FormulaOutputSpec,
+) catalog.ReplayOutputs {
+	outputs := catalog.ReplayOutputs{}
+	for _, stepRef := range ord {
+		step, _ := module.LookupStep(mod, stepRef)
+		op, ok := step.(api.Operation)
+		if !ok {
+			continue
+		}
+		for slotName := range op.Outputs {
+			outputs[stepRef.String()+"."+string(slotName)] = outputSpec(stepRef, slotName)
+		}
+	}
+	return outputs
+}
+
+// evalSetup is everything needed to evaluate a module, short of evaluating it.
+type evalSetup struct {
+	ord          []api.SubmoduleStepRef
+	pins         funcs.Pins
+	overridden   map[api.SubmoduleSlotRef]api.WareID // Pins replaced by Options.PinOverrides.
+	wareSourcing api.WareSourcing
+	wareStaging  api.WareStaging
+	outputSpec   func(api.SubmoduleStepRef, api.SlotName) api.FormulaOutputSpec
+}
+
+// prepare orders the module's steps (pruned as opts says), resolves its
+// imports, and configures warehousing and how outputs are packed,
+// reporting progress as it goes.
+func prepare(
+	ctx context.Context,
+	ws workspace.Workspace,
+	lm layout.Module,
+	sagaName *catalog.SagaName,
+	modName api.ModuleName,
+	mod api.Module,
+	opts Options,
+	prog progress,
+) (*evalSetup, error) {
 	// Process the module DAG into a linear toposort of steps.
 	//  Any impossible graphs inside the module will error out here
 	//   (but we won't get to checking imports and ingests until later).
-	fmt.Fprintf(stderr, "module loaded\n")
 	ord, err := funcs.ModuleOrderStepsDeep(mod)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Fprintf(stderr, "module contains %d steps\n", len(ord))
-	fmt.Fprintf(stderr, "module evaluation plan order:\n")
-	for i, fullStepRef := range ord {
-		fmt.Fprintf(stderr, "  - %.2d: %s\n", i+1, fullStepRef)
+	if opts.pruned() {
+		ord, err = module.PruneOrder(mod, ord, opts.TargetSteps, opts.TargetExports)
+		if err != nil {
+			return nil, err
+		}
 	}
+	prog.planned(ord)
 
 	// Configure defaults for warehousing.
 	//  We'll always consider the workspace's local dirs as a data source;
@@ -51,26 +344,38 @@ func EvalModule(
 	//   (both for intermediates, final exports, and ingests).
 	//  The wareSourcing config may be accumulated along with others per formula;
 	//   this is just the starting point minimum configuration.
-	wareStaging := api.WareStaging{ByPackType: map[api.PackType]api.WarehouseLocation{"tar": ws.Layout.StagingWarehouseLoc()}}
-	wareSourcing := api.WareSourcing{}
-	wareSourcing.AppendByPackType("tar", ws.Layout.StagingWarehouseLoc())
-	// Make the workspace's local warehouse dir if it doesn't exist.
-	os.Mkdir(ws.Layout.StagingWarehousePath(), 0755)
+	//  There's a staging warehouse per pack type outputs can be packed as.
+	wareStaging := ws.WareStaging()
+	wareSourcing := ws.StagingWareSourcing()
+	// Make the workspace's local warehouse dirs if they don't exist.
+	for _, pth := range ws.StagingWarehousePaths() {
+		os.Mkdir(pth, 0755)
+	}
+	// Check the workspace config for how to pack outputs refers to real ones.
+	if err := checkOutputOverrides(mod, ws.OutputOverrides(modName)); err != nil {
+		return nil, err
+	}
+	outputSpec := func(step api.SubmoduleStepRef, slot api.SlotName) api.FormulaOutputSpec {
+		return ws.OutputSpec(modName, step, slot)
+	}
 
 	// Prepare catalog view tools.
-	//  Definitely includes the workspace catalog;
+	//  Definitely includes the workspace catalog (plus any extra catalog roots
+	//   the workspace config asks for);
 	//  may also include a view of "candidates" data, if a sagaName arg is present.
-	viewLineageTool, viewWarehousesTool := hitchGadget.ViewTools([]catalog.Tree{
-		// refactor note: we used to stack several catalog dirs here, but have backtracked from allowing that.
-		// so it's possible there's a layer of abstraction here that should be removed outright; have not fully reviewed.
-		{ws.Layout.CatalogRoot()},
-	}...)
+	//  Any replacements the workspace config asks for go on top of all that.
+	viewLineageTool, viewWarehousesTool := hitchGadget.ViewTools(ws.CatalogTrees()...)
 	if sagaName != nil {
 		viewLineageTool = hitchGadget.WithCandidates(
 			viewLineageTool,
-			catalog.Tree{filepath.Join(ws.Layout.WorkspaceRoot(), ".timeless/candidates/", sagaName.String())},
+			catalog.CandidateTree(ws.Layout, *sagaName),
 		)
 	}
+	viewLineageTool, viewWarehousesTool = hitchGadget.WithReplacements(
+		viewLineageTool,
+		viewWarehousesTool,
+		ws.Replacements(),
+	)
 
 	// Resolve all imports.
 	//  This includes both viewing catalogs (cheap, fast),
@@ -81,68 +386,46 @@ func EvalModule(
 	}.Resolve
 	pins, pinWs, err := funcs.ResolvePins(mod, viewLineageTool, viewWarehousesTool, resolveTool)
 	if err != nil {
-		return errcat.Errorf(
+		return nil, errcat.Errorf(
 			"reach-resolve-imports-failed",
 			"cannot resolve imports: %s", err)
 	}
 	wareSourcing.Append(*pinWs)
-	fmt.Fprintf(stderr, "imports pinned to hashes:\n")
-	allSlotRefs := []api.SubmoduleSlotRef{}
-	for k, _ := range pins {
-		allSlotRefs = append(allSlotRefs, k)
-	}
-	sort.Sort(api.SubmoduleSlotRefList(allSlotRefs))
-	for _, k := range allSlotRefs {
-		fmt.Fprintf(stderr, "  - %q: %s\n", k, pins[k])
-	}
-
-	// Ensure memoization is enabled.
-	//  Future: this is a bit of an odd reach-around way to configure this.
-	//  PRs which propose more/better ways to enable and parameterize memoization would be extremely welcomed.
-	os.Setenv("REPEATR_MEMODIR", ws.Layout.MemoDir())
-	os.Mkdir(ws.Layout.MemoDir(), 0755) // Errors ignored.  Repeatr will emit warns, but work.
-
-	// Begin the evaluation!
-	exports, err := module.Evaluate(
-		context.Background(),
-		mod,
-		ord,
-		pins,
-		wareSourcing,
-		wareStaging,
-		repeatrclient.Run,
-	)
+	overridden, overrideWs, err := applyPinOverrides(ctx, pins, opts.PinOverrides, viewLineageTool, viewWarehousesTool)
 	if err != nil {
-		return fmt.Errorf("evaluating module: %s", err)
-	}
-	fmt.Fprintf(stderr, "module eval complete.\n")
-
-	// Print the results!
-	//  This goes onto stdout as json, so it's parsible and pipeable.
-	fmt.Fprintf(stderr, "module exports:\n")
-	atl_exports := atlas.MustBuild(api.WareID_AtlasEntry)
-	if err := refmt.NewMarshallerAtlased(
-		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
-		stdout,
-		atl_exports,
-	).Marshal(exports); err != nil {
-		panic(err)
+		return nil, err
 	}
+	wareSourcing.Append(*overrideWs)
+	prog.pinned(pins, overridden)
+	return &evalSetup{
+		ord:          ord,
+		pins:         pins,
+		overridden:   overridden,
+		wareSourcing: wareSourcing,
+		wareStaging:  wareStaging,
+		outputSpec:   outputSpec,
+	}, nil
+}
 
-	// Save a "candidate" release!
-	// (Unless there's no saga name, that must've been on purpose.)
-	if sagaName == nil {
-		return nil
+// plan evaluates the module with a stand-in for repeatr (see planner),
+// resolving every step's formula without running anything.
+func plan(ctx context.Context, ws workspace.Workspace, mod api.Module, ev *evalSetup, pruned bool) (*planner, error) {
+	p := &planner{
+		memoDir:  ws.MemoDir(),
+		memo:     map[api.FormulaSetupHash]bool{},
+		contexts: map[api.FormulaSetupHash]repeatr.FormulaContext{},
 	}
-	modName, err := ws.ResolveModuleName(lm)
-	if err != nil {
-		return err // FIXME detect this WAY earlier.
-	}
-	if err := catalog.SaveCandidateRelease(ws.Layout, *sagaName, modName, exports, stderr); err != nil {
-		return err
-	}
-	if err := catalog.SaveCandidateReplay(ws.Layout, *sagaName, modName, mod, stderr); err != nil {
-		return err
+	if _, err := module.Evaluate(
+		ctx,
+		mod,
+		ev.ord,
+		ev.pins,
+		ev.wareSourcing,
+		ev.wareStaging,
+		p.run,
+		module.EvalOptions{Events: p, Pruned: pruned, OutputSpec: ev.outputSpec},
+	); err != nil {
+		return nil, fmt.Errorf("planning module: %s", err)
 	}
-	return nil
+	return p, nil
 }
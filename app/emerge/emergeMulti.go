@@ -1,9 +1,12 @@
 package emergeApp
 
 import (
+	"context"
 	"fmt"
 	"io"
 
+	"github.com/warpfork/go-errcat"
+
 	"go.polydawn.net/go-timeless-api"
 	"go.polydawn.net/reach/gadgets/catalog"
 	"go.polydawn.net/reach/gadgets/commission"
@@ -12,35 +15,50 @@ import (
 )
 
 func EmergeMulti(
+	ctx context.Context, // cancelling this interrupts the evaluation.
 	ws workspace.Workspace, // needed for... everything.
 	moduleNames []api.ModuleName, // list of modules by name that we def want eval'd.
+	recursive bool, // if false, only the modules listed are eval'd (though still in commission order).
 	sagaName catalog.SagaName, // required so we can pass catalogs between modules.
+	opts Options,
 	stdout, stderr io.Writer,
 ) error {
-	fmt.Printf("asks: %v\n", moduleNames)
-	order, err := commission.CommissionOrder(
+	commissionOrder := commission.CommissionOrderAmong
+	if recursive {
+		commissionOrder = commission.CommissionOrder
+	}
+	order, err := commissionOrder(
 		ws,
 		moduleNames...,
 	)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("cannot determine commission order of modules: %s", err)
 	}
-	fmt.Printf("order found: %v\n", order)
 	for _, modName := range order {
 		modLayout := ws.GetModuleLayout(modName)
+		if modLayout == nil {
+			return fmt.Errorf("module %q is not mapped to any path by the workspace config", modName)
+		}
 		mod, err := module.Load(*modLayout)
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("error loading module %q: %s", modName, err)
 		}
 		err = EvalModule(
+			ctx,
 			ws,
 			*modLayout,
 			&sagaName,
 			*mod,
+			opts,
 			stdout, stderr,
 		)
 		if err != nil {
-			panic(err)
+			// Jobs failing, or being interrupted, are ordinary outcomes;
+			//  later modules can't proceed, but it's no crash.
+			if errcat.Category(err) == ErrJobsFailed || ctx.Err() != nil {
+				return err
+			}
+			return fmt.Errorf("evaluating module %q: %s", modName, err)
 		}
 	}
 	return nil
@@ -0,0 +1,99 @@
+package emergeApp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/go-timeless-api/hitch"
+)
+
+// PinOverride replaces the ware an import is pinned to, regardless of what
+// the import says.  Exactly one of WareID and CatalogRef is set.
+type PinOverride struct {
+	Slot       api.SubmoduleSlotRef
+	WareID     *api.WareID
+	CatalogRef *api.ImportRef_Catalog
+}
+
+// ParsePinOverride parses a pin override of the form "slot=wareID" or
+// "slot=catalog:module:release:item".  The slot is named as in the
+// "imports pinned" listing: the import's name, prefixed by the path of
+// submodules containing it, if any (e.g. "base" or "submodule.image").
+func ParsePinOverride(s string) (*PinOverride, error) {
+	ss := strings.SplitN(s, "=", 2)
+	if len(ss) != 2 || ss[0] == "" {
+		return nil, fmt.Errorf("pin override %q should be of the form slot=wareID or slot=catalog:module:release:item", s)
+	}
+	override := &PinOverride{Slot: api.SubmoduleSlotRef{"", api.SlotRef{"", api.SlotName(ss[0])}}}
+	if i := strings.LastIndex(ss[0], "."); i >= 0 {
+		override.Slot = api.SubmoduleSlotRef{api.SubmoduleRef(ss[0][:i]), api.SlotRef{"", api.SlotName(ss[0][i+1:])}}
+	}
+	if strings.HasPrefix(ss[1], "catalog:") {
+		importRef, err := api.ParseImportRef(ss[1])
+		if err != nil {
+			return nil, fmt.Errorf("pin override %q: %s", s, err)
+		}
+		catalogRef := importRef.(api.ImportRef_Catalog)
+		override.CatalogRef = &catalogRef
+		return override, nil
+	}
+	wareID, err := api.ParseWareID(ss[1])
+	if err != nil {
+		return nil, fmt.Errorf("pin override %q: %s", s, err)
+	}
+	override.WareID = &wareID
+	return override, nil
+}
+
+// applyPinOverrides replaces entries in the pins map.
+//
+// Only slots which are already in the pins map can be overridden (so, not
+// parent imports); it's an error to name any other slot, since that's
+// almost certainly a typo.  Returns what each overridden slot was pinned
+// to before, and where to find any wares the overrides pull in from catalogs.
+func applyPinOverrides(
+	ctx context.Context,
+	pins funcs.Pins,
+	overrides []PinOverride,
+	viewLineageTool hitch.ViewLineageTool,
+	viewWarehousesTool hitch.ViewWarehousesTool,
+) (map[api.SubmoduleSlotRef]api.WareID, *api.WareSourcing, error) {
+	previous := make(map[api.SubmoduleSlotRef]api.WareID, len(overrides))
+	wareSourcing := &api.WareSourcing{}
+	for _, override := range overrides {
+		was, ok := pins[override.Slot]
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot override pin for %q: module has no catalog or ingest import by that name", override.Slot)
+		}
+		wareID := override.WareID
+		if override.CatalogRef != nil {
+			ref := *override.CatalogRef
+			lin, err := viewLineageTool(ctx, ref.ModuleName)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot override pin for %q: %s", override.Slot, err)
+			}
+			rel, err := hitch.LineagePluckReleaseByName(*lin, ref.ReleaseName)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot override pin for %q: %s", override.Slot, err)
+			}
+			itemWareID, ok := rel.Items[ref.ItemName]
+			if !ok {
+				return nil, nil, fmt.Errorf("cannot override pin for %q: release %s:%s has no item %q", override.Slot, ref.ModuleName, ref.ReleaseName, ref.ItemName)
+			}
+			wareID = &itemWareID
+			mirrors, err := viewWarehousesTool(ctx, ref.ModuleName)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot override pin for %q: %s", override.Slot, err)
+			}
+			wareSourcing.Append(*mirrors)
+		}
+		if _, ok := previous[override.Slot]; !ok {
+			previous[override.Slot] = was
+		}
+		pins[override.Slot] = *wareID
+	}
+	return previous, wareSourcing, nil
+}
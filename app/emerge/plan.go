@@ -0,0 +1,227 @@
+package emergeApp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polydawn/refmt"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/obj/atlas"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/repeatr"
+)
+
+// PlannedStep describes what would happen to one operation step,
+// if the module were evaluated for real.
+type PlannedStep struct {
+	Step      string
+	SetupHash string
+	Memoized  bool        // True if repeatr has a memoized result for this formula, so it would not actually run.
+	Formula   api.Formula // As resolved.  Inputs from upstream steps which aren't memoized are placeholders.
+}
+
+var PlannedStep_AtlasEntry = atlas.BuildEntry(PlannedStep{}).StructMap().
+	SetKeyValue("step", "Step").
+	SetKeyValue("setupHash", "SetupHash").
+	SetKeyValue("memoized", "Memoized").
+	SetKeyValue("formula", "Formula").
+	Complete()
+
+var Atlas_Plan = atlas.MustBuild(
+	PlannedStep_AtlasEntry,
+	api.Formula_AtlasEntry,
+	api.FormulaAction_AtlasEntry,
+	api.FormulaUserinfo_AtlasEntry,
+	api.FormulaOutputSpec_AtlasEntry,
+	api.FilesetFilters_AtlasEntry,
+	api.WareID_AtlasEntry,
+)
+
+// planner stands in for repeatr when evaluating a module in plan mode.
+//
+// Its run func never runs anything: if the memo dir has a record for
+// the formula, it returns that (so downstream steps resolve exactly as
+// they would for real); otherwise, it makes up placeholder wares for
+// the outputs.  Placeholders are never memo hits, so everything
+// downstream of a step that would really run is (correctly) reported
+// as needing to run too.
+type planner struct {
+	memoDir string
+
+	mu       sync.Mutex
+	steps    []PlannedStep
+	memo     map[api.FormulaSetupHash]bool
+	contexts map[api.FormulaSetupHash]repeatr.FormulaContext
+}
+
+// placeholderPrefix marks the hashes of wares made up by the planner.
+const placeholderPrefix = "placeholder-"
+
+func (p *planner) run(
+	ctx context.Context,
+	frm api.Formula,
+	frmCtx repeatr.FormulaContext,
+	_ repeatr.InputControl,
+	_ repeatr.Monitor,
+) (*api.FormulaRunRecord, error) {
+	setupHash := frm.SetupHash()
+	p.mu.Lock()
+	p.contexts[setupHash] = frmCtx
+	p.mu.Unlock()
+	if rr := loadMemo(p.memoDir, setupHash); rr != nil {
+		p.mu.Lock()
+		p.memo[setupHash] = true
+		p.mu.Unlock()
+		return rr, nil
+	}
+	rr := &api.FormulaRunRecord{
+		FormulaID: setupHash,
+		Results:   make(map[api.AbsPath]api.WareID, len(frm.Outputs)),
+	}
+	for pth, spec := range frm.Outputs {
+		rr.Results[pth] = api.WareID{spec.PackType, placeholderPrefix + string(setupHash) + ":" + string(pth)}
+	}
+	return rr, nil
+}
+
+// hasPlaceholders returns true if any of the formula's inputs were made up by the planner.
+func hasPlaceholders(frm api.Formula) bool {
+	for _, wareID := range frm.Inputs {
+		if strings.HasPrefix(wareID.Hash, placeholderPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadMemo returns repeatr's memoized run record for a formula,
+// or nil if there isn't one (or it can't be read, in which case repeatr
+// wouldn't use it either).
+func loadMemo(memoDir string, setupHash api.FormulaSetupHash) *api.FormulaRunRecord {
+	f, err := os.Open(filepath.Join(memoDir, string(setupHash)))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var rr api.FormulaRunRecord
+	if err := refmt.NewUnmarshallerAtlased(json.DecodeOptions{}, f, api.Atlas_Formula).Unmarshal(&rr); err != nil {
+		return nil
+	}
+	return &rr
+}
+
+// The planner is also an EventSink, so it can collect every step as it's resolved.
+
+func (p *planner) StepResolved(step api.SubmoduleStepRef, frm api.Formula) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.steps = append(p.steps, PlannedStep{
+		Step:      step.String(),
+		SetupHash: string(frm.SetupHash()),
+		Formula:   frm,
+	})
+}
+
+func (p *planner) StepStarted(step api.SubmoduleStepRef) {}
+
+func (p *planner) StepOutput(step api.SubmoduleStepRef, line string) {}
+
+func (p *planner) StepFinished(step api.SubmoduleStepRef, record *api.OperationRecord, err error, elapsed time.Duration) {
+}
+
+// report prints the plan: a summary on stderr, and the full plan as json on stdout.
+func (p *planner) report(stdout, stderr io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(stderr, "module evaluation plan:\n")
+	for i := range p.steps {
+		step := &p.steps[i]
+		step.Memoized = p.memo[api.FormulaSetupHash(step.SetupHash)]
+		status := "would run"
+		if step.Memoized {
+			status = "memoized"
+		}
+		fmt.Fprintf(stderr, "  - %s: %s (formula %s)\n", step.Step, status, step.SetupHash)
+	}
+	if err := refmt.NewMarshallerAtlased(
+		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
+		stdout,
+		Atlas_Plan,
+	).Marshal(p.steps); err != nil {
+		panic(err)
+	}
+}
+
+// ExportedFormula is a formula together with the context needed to run it:
+// the same shape repeatr accepts for a formula file.
+type ExportedFormula struct {
+	Formula api.Formula
+	Context repeatr.FormulaContext
+}
+
+var ExportedFormula_AtlasEntry = atlas.BuildEntry(ExportedFormula{}).StructMap().
+	SetKeyValue("formula", "Formula").
+	SetKeyValue("context", "Context").
+	Complete()
+
+var FormulaContext_AtlasEntry = atlas.BuildEntry(repeatr.FormulaContext{}).StructMap().
+	SetKeyValue("fetchUrls", "FetchUrls").
+	SetKeyValue("saveUrls", "SaveUrls").
+	Complete()
+
+var Atlas_ExportedFormula = atlas.MustBuild(
+	ExportedFormula_AtlasEntry,
+	FormulaContext_AtlasEntry,
+	api.Formula_AtlasEntry,
+	api.FormulaAction_AtlasEntry,
+	api.FormulaUserinfo_AtlasEntry,
+	api.FormulaOutputSpec_AtlasEntry,
+	api.FilesetFilters_AtlasEntry,
+	api.WareID_AtlasEntry,
+)
+
+// export writes each planned step's formula and formula context into dir,
+// one file per step, named by step ref, and lists the files on stdout.
+//
+// Steps downstream of a step which isn't memoized get placeholder inputs;
+// those files are still written (they're useful to read), but we warn,
+// because repeatr can't run them as-is.
+func (p *planner) export(dir string, stdout, stderr io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, step := range p.steps {
+		setupHash := api.FormulaSetupHash(step.SetupHash)
+		filename := filepath.Join(dir, strings.Replace(step.Step, string(filepath.Separator), "_", -1)+".formula.json")
+		if err := writeExportedFormula(filename, ExportedFormula{step.Formula, p.contexts[setupHash]}); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s\n", filename)
+		if hasPlaceholders(step.Formula) {
+			fmt.Fprintf(stderr, "warning: some inputs of step %s are placeholders, because upstream steps have not been run yet\n", step.Step)
+		}
+	}
+	return nil
+}
+
+func writeExportedFormula(filename string, ef ExportedFormula) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return refmt.NewMarshallerAtlased(
+		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
+		f,
+		Atlas_ExportedFormula,
+	).Marshal(ef)
+}
@@ -0,0 +1,278 @@
+package emergeApp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/polydawn/refmt"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/obj/atlas"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/reach/gadgets/module"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// progress reports what EvalModule is doing: either as text for humans,
+// or as a stream of json events for machines.
+type progress interface {
+	module.EventSink
+
+	// logSink returns where module.Evaluate should send its logs.
+	logSink() module.LogSink
+
+	planned(ord []api.SubmoduleStepRef)
+	pinned(pins funcs.Pins, overridden map[api.SubmoduleSlotRef]api.WareID)
+	exported(exports map[api.ItemName]api.WareID)
+	failed(failures *module.JobFailures)
+}
+
+func newProgress(logFormat string, modName api.ModuleName, stdout, stderr io.Writer) (progress, error) {
+	switch logFormat {
+	case "", LogFormatText:
+		return textProgress{stdout, stderr}, nil
+	case LogFormatJson:
+		return &jsonProgress{modName: modName, w: stdout}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (should be %q or %q)", logFormat, LogFormatText, LogFormatJson)
+	}
+}
+
+func sortedSlotRefs(pins funcs.Pins) []api.SubmoduleSlotRef {
+	allSlotRefs := []api.SubmoduleSlotRef{}
+	for k, _ := range pins {
+		allSlotRefs = append(allSlotRefs, k)
+	}
+	sort.Sort(api.SubmoduleSlotRefList(allSlotRefs))
+	return allSlotRefs
+}
+
+// textProgress is the human-readable progress report:
+// messages on stderr, step logs in boxes on stderr, and exports as json on stdout.
+type textProgress struct {
+	stdout, stderr io.Writer
+}
+
+func (p textProgress) logSink() module.LogSink {
+	return module.NewLogSink(p.stderr)
+}
+
+func (p textProgress) planned(ord []api.SubmoduleStepRef) {
+	fmt.Fprintf(p.stderr, "module loaded\n")
+	fmt.Fprintf(p.stderr, "module contains %d steps\n", len(ord))
+	fmt.Fprintf(p.stderr, "module evaluation plan order:\n")
+	for i, fullStepRef := range ord {
+		fmt.Fprintf(p.stderr, "  - %.2d: %s\n", i+1, fullStepRef)
+	}
+}
+
+func (p textProgress) pinned(pins funcs.Pins, overridden map[api.SubmoduleSlotRef]api.WareID) {
+	fmt.Fprintf(p.stderr, "imports pinned to hashes:\n")
+	for _, k := range sortedSlotRefs(pins) {
+		if was, ok := overridden[k]; ok {
+			fmt.Fprintf(p.stderr, "  - %q: %s (overridden; was %s)\n", k, pins[k], was)
+			continue
+		}
+		fmt.Fprintf(p.stderr, "  - %q: %s\n", k, pins[k])
+	}
+}
+
+func (p textProgress) exported(exports map[api.ItemName]api.WareID) {
+	fmt.Fprintf(p.stderr, "module eval complete.\n")
+	// Print the results!
+	//  This goes onto stdout as json, so it's parsible and pipeable.
+	fmt.Fprintf(p.stderr, "module exports:\n")
+	atl_exports := atlas.MustBuild(api.WareID_AtlasEntry)
+	if err := refmt.NewMarshallerAtlased(
+		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
+		p.stdout,
+		atl_exports,
+	).Marshal(exports); err != nil {
+		panic(err)
+	}
+}
+
+func (p textProgress) failed(failures *module.JobFailures) {
+	fmt.Fprintf(p.stderr, "module eval completed with failures.\n")
+	fmt.Fprintf(p.stderr, "failed steps:\n")
+	for _, failure := range failures.Failed {
+		if failure.Err != nil {
+			fmt.Fprintf(p.stderr, "  - %s: %s\n", failure.Step, failure.Err)
+			continue
+		}
+		fmt.Fprintf(p.stderr, "  - %s: exit code %d\n", failure.Step, failure.ExitCode)
+	}
+	if len(failures.Skipped) > 0 {
+		fmt.Fprintf(p.stderr, "skipped steps, because they depend on failed steps:\n")
+		for _, step := range failures.Skipped {
+			fmt.Fprintf(p.stderr, "  - %s\n", step)
+		}
+	}
+}
+
+// The text report already shows step progress in the step logs.
+func (textProgress) StepResolved(api.SubmoduleStepRef, api.Formula)                                {}
+func (textProgress) StepStarted(api.SubmoduleStepRef)                                              {}
+func (textProgress) StepOutput(api.SubmoduleStepRef, string)                                       {}
+func (textProgress) StepFinished(api.SubmoduleStepRef, *api.OperationRecord, error, time.Duration) {}
+
+// Event is one line of the json progress stream.
+// Which fields are set depends on the kind of event.
+type Event struct {
+	Time        time.Time
+	Kind        string // One of the EventKind* consts.
+	Module      api.ModuleName
+	Step        string                      // For step events.
+	Plan        []string                    // For EventKindPlan: every step, in evaluation order.
+	Slot        string                      // For EventKindImportPinned.
+	WareID      *api.WareID                 // For EventKindImportPinned.
+	Overridden  *api.WareID                 // For EventKindImportPinned, if the pin was overridden: what it was before.
+	FormulaHash string                      // For EventKindStepResolved.
+	Line        string                      // For EventKindStepOutput.
+	ExitCode    *int                        // For EventKindStepFinished, if the step ran.
+	Duration    float64                     // For EventKindStepFinished, in seconds.
+	Results     map[api.SlotName]api.WareID // For EventKindStepFinished, if the step ran.
+	Error       string                      // For EventKindStepFinished, if the step failed to run.
+	Exports     map[api.ItemName]api.WareID // For EventKindExports.
+	Failed      []string                    // For EventKindFailures.  (Exit codes were in the step-finished events.)
+	Skipped     []string                    // For EventKindFailures.
+}
+
+const (
+	EventKindPlan         = "plan"
+	EventKindImportPinned = "import-pinned"
+	EventKindStepResolved = "step-resolved"
+	EventKindStepStarted  = "step-started"
+	EventKindStepOutput   = "step-output"
+	EventKindStepFinished = "step-finished"
+	EventKindExports      = "exports"
+	EventKindFailures     = "failures"
+)
+
+var Event_AtlasEntry = atlas.BuildEntry(Event{}).StructMap().
+	AddField("Time", atlas.StructMapEntry{SerialName: "time"}).
+	AddField("Kind", atlas.StructMapEntry{SerialName: "event"}).
+	AddField("Module", atlas.StructMapEntry{SerialName: "module", OmitEmpty: true}).
+	AddField("Step", atlas.StructMapEntry{SerialName: "step", OmitEmpty: true}).
+	AddField("Plan", atlas.StructMapEntry{SerialName: "plan", OmitEmpty: true}).
+	AddField("Slot", atlas.StructMapEntry{SerialName: "slot", OmitEmpty: true}).
+	AddField("WareID", atlas.StructMapEntry{SerialName: "wareID", OmitEmpty: true}).
+	AddField("Overridden", atlas.StructMapEntry{SerialName: "overridden", OmitEmpty: true}).
+	AddField("FormulaHash", atlas.StructMapEntry{SerialName: "formulaHash", OmitEmpty: true}).
+	AddField("Line", atlas.StructMapEntry{SerialName: "line", OmitEmpty: true}).
+	AddField("ExitCode", atlas.StructMapEntry{SerialName: "exitCode", OmitEmpty: true}).
+	AddField("Duration", atlas.StructMapEntry{SerialName: "duration", OmitEmpty: true}).
+	AddField("Results", atlas.StructMapEntry{SerialName: "results", OmitEmpty: true}).
+	AddField("Error", atlas.StructMapEntry{SerialName: "error", OmitEmpty: true}).
+	AddField("Exports", atlas.StructMapEntry{SerialName: "exports", OmitEmpty: true}).
+	AddField("Failed", atlas.StructMapEntry{SerialName: "failed", OmitEmpty: true}).
+	AddField("Skipped", atlas.StructMapEntry{SerialName: "skipped", OmitEmpty: true}).
+	Complete()
+
+var Atlas_Event = atlas.MustBuild(
+	Event_AtlasEntry,
+	api.WareID_AtlasEntry,
+	timeAtlasEntry,
+)
+
+// timeAtlasEntry serializes times as RFC3339 strings, in UTC.
+var timeAtlasEntry = atlas.BuildEntry(time.Time{}).Transform().
+	TransformMarshal(atlas.MakeMarshalTransformFunc(
+		func(x time.Time) (string, error) {
+			return x.UTC().Format(time.RFC3339Nano), nil
+		})).
+	TransformUnmarshal(atlas.MakeUnmarshalTransformFunc(
+		func(x string) (time.Time, error) {
+			return time.Parse(time.RFC3339Nano, x)
+		})).
+	Complete()
+
+// jsonProgress emits progress as a stream of json objects on stdout, one per line.
+// Step logs are not emitted (only the repeatr output within them, as events).
+type jsonProgress struct {
+	modName api.ModuleName
+	mu      sync.Mutex
+	w       io.Writer
+}
+
+func (p *jsonProgress) emit(evt Event) {
+	evt.Time = time.Now()
+	evt.Module = p.modName
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := refmt.NewMarshallerAtlased(json.EncodeOptions{Line: []byte("\n")}, p.w, Atlas_Event).Marshal(evt); err != nil {
+		panic(err)
+	}
+}
+
+func (p *jsonProgress) logSink() module.LogSink {
+	return module.NewLogSink(ioutil.Discard)
+}
+
+func (p *jsonProgress) planned(ord []api.SubmoduleStepRef) {
+	plan := make([]string, len(ord))
+	for i, fullStepRef := range ord {
+		plan[i] = fullStepRef.String()
+	}
+	p.emit(Event{Kind: EventKindPlan, Plan: plan})
+}
+
+func (p *jsonProgress) pinned(pins funcs.Pins, overridden map[api.SubmoduleSlotRef]api.WareID) {
+	for _, k := range sortedSlotRefs(pins) {
+		wareID := pins[k]
+		evt := Event{Kind: EventKindImportPinned, Slot: k.String(), WareID: &wareID}
+		if was, ok := overridden[k]; ok {
+			evt.Overridden = &was
+		}
+		p.emit(evt)
+	}
+}
+
+func (p *jsonProgress) exported(exports map[api.ItemName]api.WareID) {
+	p.emit(Event{Kind: EventKindExports, Exports: exports})
+}
+
+func (p *jsonProgress) failed(failures *module.JobFailures) {
+	evt := Event{Kind: EventKindFailures}
+	for _, failure := range failures.Failed {
+		evt.Failed = append(evt.Failed, failure.Step.String())
+	}
+	for _, step := range failures.Skipped {
+		evt.Skipped = append(evt.Skipped, step.String())
+	}
+	p.emit(evt)
+}
+
+func (p *jsonProgress) StepResolved(step api.SubmoduleStepRef, frm api.Formula) {
+	p.emit(Event{Kind: EventKindStepResolved, Step: step.String(), FormulaHash: string(frm.SetupHash())})
+}
+
+func (p *jsonProgress) StepStarted(step api.SubmoduleStepRef) {
+	p.emit(Event{Kind: EventKindStepStarted, Step: step.String()})
+}
+
+func (p *jsonProgress) StepOutput(step api.SubmoduleStepRef, line string) {
+	p.emit(Event{Kind: EventKindStepOutput, Step: step.String(), Line: line})
+}
+
+func (p *jsonProgress) StepFinished(step api.SubmoduleStepRef, record *api.OperationRecord, err error, elapsed time.Duration) {
+	evt := Event{Kind: EventKindStepFinished, Step: step.String(), Duration: elapsed.Seconds()}
+	if record != nil {
+		exitCode := record.ExitCode
+		evt.ExitCode = &exitCode
+		evt.Results = record.Results
+	}
+	if err != nil {
+		evt.Error = err.Error()
+	}
+	p.emit(evt)
+}
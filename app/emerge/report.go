@@ -0,0 +1,231 @@
+package emergeApp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polydawn/refmt"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/obj/atlas"
+	"github.com/warpfork/go-errcat"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/reach/gadgets/layout"
+	"go.polydawn.net/reach/gadgets/module"
+)
+
+// Report records everything that happened in one evaluation of a module,
+// so builds can be audited later, and two runs can be diffed to find
+// which step's output changed.
+//
+// A report is written under `layout.Workspace.ReportsRoot()` for every
+// evaluation, whether it succeeded or not.
+type Report struct {
+	Module   api.ModuleName
+	Started  time.Time
+	Finished time.Time
+	Pins     map[string]api.WareID       // Import pins, keyed by SubmoduleSlotRef.
+	Steps    map[string]*StepReport      // Keyed by SubmoduleStepRef.  Only operation steps which were reached are present.
+	Exports  map[api.ItemName]api.WareID // Absent if the evaluation failed.
+	Error    string                      // Set if the evaluation failed.
+}
+
+// StepReport records what happened in one operation step.
+type StepReport struct {
+	Formula   *api.Formula         // As resolved.  Absent if the step failed to resolve.
+	SetupHash string               // Setup hash of the formula.
+	Record    *api.OperationRecord // Absent if the step failed to run.
+	Started   time.Time
+	Duration  float64 // In seconds.
+	Error     string  // Set if the step failed to resolve or run.
+	Reused    bool    // Set if the step's results were reused from the evaluation being resumed; then only Record.Results is set.
+}
+
+var Report_AtlasEntry = atlas.BuildEntry(Report{}).StructMap().
+	AddField("Module", atlas.StructMapEntry{SerialName: "module"}).
+	AddField("Started", atlas.StructMapEntry{SerialName: "started"}).
+	AddField("Finished", atlas.StructMapEntry{SerialName: "finished"}).
+	AddField("Pins", atlas.StructMapEntry{SerialName: "pins"}).
+	AddField("Steps", atlas.StructMapEntry{SerialName: "steps"}).
+	AddField("Exports", atlas.StructMapEntry{SerialName: "exports", OmitEmpty: true}).
+	AddField("Error", atlas.StructMapEntry{SerialName: "error", OmitEmpty: true}).
+	Complete()
+
+var StepReport_AtlasEntry = atlas.BuildEntry(StepReport{}).StructMap().
+	AddField("Formula", atlas.StructMapEntry{SerialName: "formula", OmitEmpty: true}).
+	AddField("SetupHash", atlas.StructMapEntry{SerialName: "setupHash", OmitEmpty: true}).
+	AddField("Record", atlas.StructMapEntry{SerialName: "record", OmitEmpty: true}).
+	AddField("Started", atlas.StructMapEntry{SerialName: "started"}).
+	AddField("Duration", atlas.StructMapEntry{SerialName: "duration"}).
+	AddField("Error", atlas.StructMapEntry{SerialName: "error", OmitEmpty: true}).
+	AddField("Reused", atlas.StructMapEntry{SerialName: "reused", OmitEmpty: true}).
+	Complete()
+
+var Atlas_Report = atlas.MustBuild(
+	Report_AtlasEntry,
+	StepReport_AtlasEntry,
+	api.Formula_AtlasEntry,
+	api.FormulaAction_AtlasEntry,
+	api.FormulaUserinfo_AtlasEntry,
+	api.FormulaOutputSpec_AtlasEntry,
+	api.FilesetFilters_AtlasEntry,
+	api.OperationRecord_AtlasEntry,
+	api.FormulaRunRecord_AtlasEntry,
+	api.WareID_AtlasEntry,
+	timeAtlasEntry,
+)
+
+// reporter is a module.EventSink which accumulates a Report.
+type reporter struct {
+	mu     sync.Mutex
+	report Report
+}
+
+func newReporter(modName api.ModuleName, pins funcs.Pins) *reporter {
+	r := &reporter{report: Report{
+		Module:  modName,
+		Started: time.Now(),
+		Pins:    make(map[string]api.WareID, len(pins)),
+		Steps:   map[string]*StepReport{},
+	}}
+	for k, v := range pins {
+		r.report.Pins[k.String()] = v
+	}
+	return r
+}
+
+func (r *reporter) step(step api.SubmoduleStepRef) *StepReport {
+	sr, ok := r.report.Steps[step.String()]
+	if !ok {
+		sr = &StepReport{Started: time.Now()}
+		r.report.Steps[step.String()] = sr
+	}
+	return sr
+}
+
+func (r *reporter) StepResolved(step api.SubmoduleStepRef, frm api.Formula) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sr := r.step(step)
+	sr.Formula = &frm
+	sr.SetupHash = string(frm.SetupHash())
+}
+
+func (r *reporter) StepStarted(step api.SubmoduleStepRef) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.step(step).Started = time.Now()
+}
+
+func (r *reporter) StepOutput(api.SubmoduleStepRef, string) {}
+
+func (r *reporter) StepFinished(step api.SubmoduleStepRef, record *api.OperationRecord, err error, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sr := r.step(step)
+	sr.Record = record
+	sr.Duration = elapsed.Seconds()
+	if err != nil {
+		sr.Error = err.Error()
+	}
+}
+
+// reused records the steps whose results are reused from the evaluation
+// being resumed (see module.EvalOptions.Completed), since evaluating them
+// produces no events.  Only steps in the evaluation order count.
+func (r *reporter) reused(completed map[api.SubmoduleStepRef]map[api.SlotName]api.WareID, ord []api.SubmoduleStepRef) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, stepRef := range ord {
+		results, ok := completed[stepRef]
+		if !ok {
+			continue
+		}
+		sr := r.step(stepRef)
+		sr.Record = &api.OperationRecord{Results: results}
+		sr.Reused = true
+	}
+}
+
+// finish records the outcome of the whole evaluation.
+func (r *reporter) finish(exports map[api.ItemName]api.WareID, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Finished = time.Now()
+	r.report.Exports = exports
+	if err != nil {
+		r.report.Error = err.Error()
+	}
+}
+
+// save writes the report to a new file in the workspace's reports dir,
+// and returns its path.  Files are named by start time and module name,
+// so they sort in the order the runs started.
+func (r *reporter) save(landmarks layout.Workspace) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.MkdirAll(landmarks.ReportsRoot(), 0755); err != nil {
+		return "", errcat.Errorf("reach-report-save-failed", "cannot save run report: %s", err)
+	}
+	name := r.report.Started.UTC().Format("20060102T150405.000000000Z")
+	if r.report.Module != "" {
+		name += "_" + strings.Replace(string(r.report.Module), "/", "_", -1)
+	}
+	pth := filepath.Join(landmarks.ReportsRoot(), name+".json")
+	f, err := os.OpenFile(pth, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", errcat.Errorf("reach-report-save-failed", "cannot save run report: %s", err)
+	}
+	defer f.Close()
+	if err := refmt.NewMarshallerAtlased(
+		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
+		f,
+		Atlas_Report,
+	).Marshal(r.report); err != nil {
+		return "", fmt.Errorf("cannot save run report: %s", err)
+	}
+	return pth, nil
+}
+
+// summarizeInterruption prints which operation steps of the evaluation order
+// had completed, which were interrupted, and which never started.
+func (r *reporter) summarizeInterruption(w io.Writer, mod api.Module, ord []api.SubmoduleStepRef, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var completed, interrupted, notStarted []string
+	for _, stepRef := range ord {
+		step, _ := module.LookupStep(mod, stepRef)
+		if _, ok := step.(api.Operation); !ok {
+			continue // submodules are summarized by their steps.
+		}
+		sr, ok := r.report.Steps[stepRef.String()]
+		switch {
+		case !ok:
+			notStarted = append(notStarted, stepRef.String())
+		case sr.Record != nil && sr.Error == "":
+			completed = append(completed, stepRef.String())
+		default:
+			interrupted = append(interrupted, stepRef.String())
+		}
+	}
+	fmt.Fprintf(w, "module evaluation interrupted: %s\n", reason)
+	for _, group := range []struct {
+		heading string
+		steps   []string
+	}{
+		{"completed", completed},
+		{"interrupted", interrupted},
+		{"not started", notStarted},
+	} {
+		fmt.Fprintf(w, "%s steps: %d\n", group.heading, len(group.steps))
+		for _, step := range group.steps {
+			fmt.Fprintf(w, "  - %s\n", step)
+		}
+	}
+}
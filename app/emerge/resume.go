@@ -0,0 +1,259 @@
+package emergeApp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polydawn/refmt"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/obj/atlas"
+	"github.com/warpfork/go-errcat"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/reach/gadgets/layout"
+	"go.polydawn.net/reach/gadgets/module"
+)
+
+// ResumeState is what's persisted of a module evaluation while it's in
+// progress, so that if it fails, it can be resumed (see Options.Resume)
+// without re-evaluating the steps which already completed.
+//
+// It's only valid for the same module content, with the same pins,
+// and with its outputs packed the same way.
+type ResumeState struct {
+	Module      api.ModuleName
+	ModuleHash  string                                 // Hash of the module's serial form.
+	OutputsHash string                                 // Hash of how every output is packed (see outputsHash).
+	Pins        map[string]api.WareID                  // Import pins, keyed by SubmoduleSlotRef.
+	Steps       map[string]map[api.SlotName]api.WareID // Results of completed operation steps, keyed by SubmoduleStepRef.
+}
+
+var ResumeState_AtlasEntry = atlas.BuildEntry(ResumeState{}).StructMap().
+	SetKeyValue("module", "Module").
+	SetKeyValue("moduleHash", "ModuleHash").
+	SetKeyValue("outputsHash", "OutputsHash").
+	SetKeyValue("pins", "Pins").
+	SetKeyValue("steps", "Steps").
+	Complete()
+
+var Atlas_ResumeState = atlas.MustBuild(
+	ResumeState_AtlasEntry,
+	api.WareID_AtlasEntry,
+)
+
+// moduleHash returns a hash of the module's serial form,
+// which changes if anything about the module does.
+func moduleHash(mod api.Module) string {
+	var buf bytes.Buffer
+	if err := refmt.NewMarshallerAtlased(json.EncodeOptions{}, &buf, api.Atlas_Module).Marshal(mod); err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+var atlas_outputSpecs = atlas.MustBuild(
+	api.FormulaOutputSpec_AtlasEntry,
+	api.FilesetFilters_AtlasEntry,
+)
+
+// outputsHash returns a hash of how every output of every operation in the
+// module is packed, which changes if the workspace config for that does.
+func outputsHash(mod api.Module, outputSpec func(api.SubmoduleStepRef, api.SlotName) api.FormulaOutputSpec) string {
+	specs := map[string]api.FormulaOutputSpec{}
+	var walk func(ctxPth api.SubmoduleRef, mod api.Module)
+	walk = func(ctxPth api.SubmoduleRef, mod api.Module) {
+		for stepName, step := range mod.Steps {
+			switch step := step.(type) {
+			case api.Operation:
+				stepRef := api.SubmoduleStepRef{ctxPth, stepName}
+				for slotName := range step.Outputs {
+					specs[stepRef.String()+"."+string(slotName)] = outputSpec(stepRef, slotName)
+				}
+			case api.Module:
+				walk(ctxPth.Child(stepName), step)
+			}
+		}
+	}
+	walk("", mod)
+	var buf bytes.Buffer
+	if err := refmt.NewMarshallerAtlased(json.EncodeOptions{}, &buf, atlas_outputSpecs).Marshal(specs); err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// resumer is a module.EventSink which records each step as it completes,
+// saving the ResumeState after every step.
+type resumer struct {
+	pth    string
+	stderr io.Writer // For warnings.
+
+	mu    sync.Mutex
+	state ResumeState
+}
+
+func resumeStatePath(landmarks layout.Workspace, modName api.ModuleName) string {
+	return filepath.Join(landmarks.ResumeRoot(), strings.Replace(string(modName), "/", "_", -1)+".json")
+}
+
+// newResumer starts the resume state for an evaluation.
+//
+// If resume is false, the state starts out empty (and any old state is
+// discarded).  If it's true, the old state is loaded; and it's an error if
+// there isn't one, or if the module, its pins, or how its outputs are packed
+// differ from what it was for.
+func newResumer(
+	landmarks layout.Workspace,
+	modName api.ModuleName,
+	mod api.Module,
+	pins funcs.Pins,
+	outputSpec func(api.SubmoduleStepRef, api.SlotName) api.FormulaOutputSpec,
+	resume bool,
+	stderr io.Writer,
+) (*resumer, error) {
+	r := &resumer{
+		pth:    resumeStatePath(landmarks, modName),
+		stderr: stderr,
+		state: ResumeState{
+			Module:      modName,
+			ModuleHash:  moduleHash(mod),
+			OutputsHash: outputsHash(mod, outputSpec),
+			Pins:        make(map[string]api.WareID, len(pins)),
+			Steps:       map[string]map[api.SlotName]api.WareID{},
+		},
+	}
+	for k, v := range pins {
+		r.state.Pins[k.String()] = v
+	}
+	if !resume {
+		return r, r.clear()
+	}
+	if modName == "" {
+		return nil, errcat.Errorf(ErrCannotResume, "cannot resume: module has no name")
+	}
+	old, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		return nil, errcat.Errorf(ErrCannotResume, "cannot resume: there's no failed evaluation of module %q to resume", modName)
+	}
+	if old.ModuleHash != r.state.ModuleHash {
+		return nil, errcat.Errorf(ErrCannotResume, "cannot resume: module %q has changed since the evaluation being resumed", modName)
+	}
+	if old.OutputsHash != r.state.OutputsHash {
+		return nil, errcat.Errorf(ErrCannotResume, "cannot resume: the workspace config for how module %q's outputs are packed has changed since the evaluation being resumed", modName)
+	}
+	if len(old.Pins) != len(pins) {
+		return nil, errcat.Errorf(ErrCannotResume, "cannot resume: module %q has different imports than the evaluation being resumed", modName)
+	}
+	for _, k := range sortedSlotRefs(pins) {
+		was, ok := old.Pins[k.String()]
+		if !ok {
+			return nil, errcat.Errorf(ErrCannotResume, "cannot resume: module %q has different imports than the evaluation being resumed", modName)
+		}
+		if was != pins[k] {
+			return nil, errcat.Errorf(ErrCannotResume, "cannot resume: import %q is now pinned to %s, but the evaluation being resumed had %s", k, pins[k], was)
+		}
+	}
+	for step, results := range old.Steps {
+		r.state.Steps[step] = results
+	}
+	return r, nil
+}
+
+// completed returns the results of the steps completed so far,
+// in the form module.EvalOptions.Completed wants.
+func (r *resumer) completed() map[api.SubmoduleStepRef]map[api.SlotName]api.WareID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	completed := make(map[api.SubmoduleStepRef]map[api.SlotName]api.WareID, len(r.state.Steps))
+	for step, results := range r.state.Steps {
+		completed[module.ParseStepRef(step)] = results
+	}
+	return completed
+}
+
+func (r *resumer) load() (*ResumeState, error) {
+	f, err := os.Open(r.pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errcat.Errorf(ErrCannotResume, "cannot resume: %s", err)
+	}
+	defer f.Close()
+	var state ResumeState
+	if err := refmt.NewUnmarshallerAtlased(json.DecodeOptions{}, f, Atlas_ResumeState).Unmarshal(&state); err != nil {
+		return nil, errcat.Errorf(ErrCannotResume, "cannot resume: state file %q is corrupt: %s", r.pth, err)
+	}
+	return &state, nil
+}
+
+// save writes the state, replacing the file atomically,
+// so a crash while saving leaves the previous state intact.
+func (r *resumer) save() error {
+	if r.state.Module == "" {
+		return nil // Nothing we could resume later anyway.
+	}
+	if err := os.MkdirAll(filepath.Dir(r.pth), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.pth), ".tmp.resume-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := refmt.NewMarshallerAtlased(
+		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
+		tmp,
+		Atlas_ResumeState,
+	).Marshal(r.state); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.pth)
+}
+
+// clear removes the state: when an evaluation is starting over, or succeeded.
+func (r *resumer) clear() error {
+	if r.state.Module == "" {
+		return nil
+	}
+	if err := os.Remove(r.pth); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *resumer) StepResolved(api.SubmoduleStepRef, api.Formula) {}
+func (r *resumer) StepStarted(api.SubmoduleStepRef)               {}
+func (r *resumer) StepOutput(api.SubmoduleStepRef, string)        {}
+
+func (r *resumer) StepFinished(step api.SubmoduleStepRef, record *api.OperationRecord, err error, elapsed time.Duration) {
+	if record == nil || record.ExitCode != 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.Steps[step.String()] = record.Results
+	// Failing to save only means less can be resumed later; say so, but carry on.
+	if err := r.save(); err != nil {
+		fmt.Fprintf(r.stderr, "warning: cannot save state for resuming: %s\n", err)
+	}
+}
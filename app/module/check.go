@@ -0,0 +1,66 @@
+package moduleApp
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/warpfork/go-errcat"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/reach/gadgets/catalog"
+	hitchGadget "go.polydawn.net/reach/gadgets/catalog/hitch"
+	"go.polydawn.net/reach/gadgets/module"
+	"go.polydawn.net/reach/gadgets/workspace"
+)
+
+type ErrorCategory string
+
+const (
+	// The module was checked, and has problems.
+	ErrModuleProblems = ErrorCategory("reach-module-problems")
+)
+
+// Check statically checks a module (see module.Check), printing each
+// problem found, and returns an ErrModuleProblems error if there are any.
+//
+// If unused is true, and there are no other problems, parts of the module
+// which contribute nothing to it (see module.Unused) are problems too.
+//
+// Catalog imports are looked up the same way evaluating the module would:
+// in the workspace's catalogs, the candidates of the saga (if there is one),
+// and with the workspace's replacements applied.
+func Check(
+	ctx context.Context,
+	ws workspace.Workspace,
+	sagaName *catalog.SagaName, // may have been provided as a flag.
+	mod api.Module,
+	unused bool,
+	stdout, stderr io.Writer,
+) error {
+	viewLineageTool, viewWarehousesTool := hitchGadget.ViewTools(ws.CatalogTrees()...)
+	if sagaName != nil {
+		viewLineageTool = hitchGadget.WithCandidates(
+			viewLineageTool,
+			catalog.CandidateTree(ws.Layout, *sagaName),
+		)
+	}
+	viewLineageTool, _ = hitchGadget.WithReplacements(
+		viewLineageTool,
+		viewWarehousesTool,
+		ws.Replacements(),
+	)
+
+	problems := module.Check(ctx, mod, viewLineageTool)
+	if unused && len(problems) == 0 {
+		problems = module.Unused(mod)
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(stdout, "%s\n", problem)
+	}
+	if len(problems) > 0 {
+		return errcat.Errorf(ErrModuleProblems, "module has %d problems", len(problems))
+	}
+	fmt.Fprintf(stderr, "module ok\n")
+	return nil
+}
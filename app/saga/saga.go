@@ -0,0 +1,202 @@
+package sagaApp
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/polydawn/go-errcat"
+	"github.com/polydawn/refmt"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/obj/atlas"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/hitch"
+	"go.polydawn.net/reach/gadgets/catalog"
+	"go.polydawn.net/reach/gadgets/workspace"
+)
+
+// List prints the name of every saga in the workspace, one per line.
+func List(ws workspace.Workspace, stdout, stderr io.Writer) error {
+	sagaNames, err := catalog.ListSagas(ws.Layout)
+	if err != nil {
+		return err
+	}
+	for _, sagaName := range sagaNames {
+		fmt.Fprintf(stdout, "%s\n", sagaName)
+	}
+	return nil
+}
+
+// Show prints every module with a candidate release in the saga,
+// and the candidate items, as json.
+func Show(ws workspace.Workspace, sagaName catalog.SagaName, stdout, stderr io.Writer) error {
+	candidates, err := loadCandidates(ws, sagaName)
+	if err != nil {
+		return err
+	}
+	output := make(map[api.ModuleName]map[api.ItemName]api.WareID, len(candidates))
+	for _, cand := range candidates {
+		output[cand.modName] = cand.release.Items
+	}
+	atl_exports := atlas.MustBuild(api.WareID_AtlasEntry)
+	if err := refmt.NewMarshallerAtlased(
+		json.EncodeOptions{Line: []byte("\n"), Indent: []byte("\t")},
+		stdout,
+		atl_exports,
+	).Marshal(output); err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+// Discard removes a saga and all its candidate releases.
+func Discard(ws workspace.Workspace, sagaName catalog.SagaName, stdout, stderr io.Writer) error {
+	if err := catalog.DiscardSaga(ws.Layout, sagaName); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "saga %q discarded\n", sagaName)
+	return nil
+}
+
+// Commit promotes the candidate release of every module in a saga into
+// a real release in the workspace catalog, named releaseName.
+//
+// The new releases are prepended to each module's lineage (creating the
+// lineage, if the module has never had a release before).
+// The mirrors file for each module also gets entries for each new ware,
+// pointing at the given warehouses; and the replay instructions saved
+// with each candidate are saved with the new release.
+//
+// All modules are checked before any are written: if any module already
+// has a release by the same name, nothing is committed.
+// The saga itself is left in place; use Discard when done with it.
+func Commit(
+	ws workspace.Workspace,
+	sagaName catalog.SagaName,
+	releaseName api.ReleaseName,
+	mirrors []api.WarehouseLocation, // warehouses where the wares can be found.
+	stdout, stderr io.Writer,
+) error {
+	switch releaseName {
+	case "":
+		return errcat.Errorf(hitch.ErrUsage, "release name cannot be empty")
+	case "candidate":
+		return errcat.Errorf(hitch.ErrUsage, "release name %q is reserved", releaseName)
+	}
+	// Make sure nothing stale gets committed.
+	//  Saving candidates already does this, but might have been interrupted.
+	if err := catalog.EvictStaleCandidates(ws.Layout, sagaName, stderr); err != nil {
+		return err
+	}
+	candidates, err := loadCandidates(ws, sagaName)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return errcat.Errorf(hitch.ErrUsage, "saga %q has no candidates to commit", sagaName)
+	}
+
+	// Compute all the new lineages first.
+	//  We don't want to write anything until we know every module is good to go.
+	tree := catalog.Tree{ws.Layout.CatalogRoot()}
+	lineages := make([]api.Lineage, len(candidates))
+	for i, cand := range candidates {
+		rel := *cand.release
+		rel.Name = releaseName
+		lin, err := tree.LoadModuleLineage(cand.modName)
+		switch errcat.Category(err) {
+		case nil:
+			if _, err := hitch.LineagePluckReleaseByName(*lin, releaseName); err == nil {
+				return errcat.ErrorDetailed(
+					hitch.ErrUsage,
+					fmt.Sprintf("cannot commit saga %q: module %q already has a release named %q", sagaName, cand.modName, releaseName),
+					map[string]string{
+						"ref": string(cand.modName),
+					})
+			}
+			lin, err = hitch.LineagePrependRelease(*lin, rel)
+			if err != nil {
+				return err
+			}
+		case hitch.ErrNoSuchLineage:
+			lin = &api.Lineage{
+				Name:     cand.modName,
+				Releases: []api.Release{rel},
+			}
+		default:
+			return err
+		}
+		lineages[i] = *lin
+	}
+
+	// Write them all out.
+	//  Lineage first: the mirrors file can't be written until the module dir exists.
+	for i, cand := range candidates {
+		if err := tree.SaveModuleLineage(cand.modName, lineages[i]); err != nil {
+			return err
+		}
+		wareSourcing, err := tree.LoadModuleMirrors(cand.modName)
+		if err != nil {
+			return err
+		}
+		if wareSourcing == nil {
+			wareSourcing = &api.WareSourcing{}
+		}
+		for _, wareID := range cand.release.Items {
+			wareSourcing.AppendByWare(wareID, mirrors...)
+		}
+		if err := tree.SaveModuleMirrors(cand.modName, *wareSourcing); err != nil {
+			return err
+		}
+		if cand.replay != nil {
+			if err := tree.SaveModuleReplay(cand.modName, releaseName, *cand.replay); err != nil {
+				return err
+			}
+		}
+		if cand.outputs != nil {
+			if err := tree.SaveModuleOutputs(cand.modName, releaseName, cand.outputs); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(stderr, "committed %s:%s\n", cand.modName, releaseName)
+	}
+	return nil
+}
+
+type candidate struct {
+	modName api.ModuleName
+	release *api.Release
+	replay  *api.Module           // may be nil, if no replay was saved.
+	outputs catalog.ReplayOutputs // may be nil, likewise.
+}
+
+// loadCandidates loads the candidate release of every module in a saga.
+// They're returned in order of module name.
+func loadCandidates(ws workspace.Workspace, sagaName catalog.SagaName) ([]candidate, error) {
+	tree := catalog.CandidateTree(ws.Layout, sagaName)
+	modNames, err := tree.ListModules()
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]candidate, len(modNames))
+	for i, modName := range modNames {
+		lin, err := tree.LoadModuleLineage(modName)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := hitch.LineagePluckReleaseByName(*lin, "candidate")
+		if err != nil {
+			return nil, err
+		}
+		replay, err := tree.LoadModuleReplay(modName, "candidate")
+		if err != nil {
+			return nil, err
+		}
+		outputs, err := tree.LoadModuleOutputs(modName, "candidate")
+		if err != nil {
+			return nil, err
+		}
+		candidates[i] = candidate{modName, rel, replay, outputs}
+	}
+	return candidates, nil
+}
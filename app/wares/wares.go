@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"path/filepath"
 
 	"github.com/polydawn/refmt"
 	"github.com/polydawn/refmt/json"
@@ -20,7 +19,7 @@ import (
 )
 
 func ListCandidates(ws workspace.Workspace, moduleName api.ModuleName, sagaName catalog.SagaName, itemName *api.ItemName, stdout, stderr io.Writer) error {
-	tree := catalog.Tree{filepath.Join(ws.Layout.WorkspaceRoot(), ".timeless/candidates/", sagaName.String())}
+	tree := catalog.CandidateTree(ws.Layout, sagaName)
 	lineage, err := tree.LoadModuleLineage(moduleName)
 	if err != nil {
 		return err
@@ -50,14 +49,10 @@ func ListCandidates(ws workspace.Workspace, moduleName api.ModuleName, sagaName
 	return nil
 }
 
-func ListReleases(ws workspace.Workspace, moduleName api.ModuleName, releaseName *api.ReleaseName, itemName *api.ItemName, stdout, stderr io.Writer) error {
-	viewLineageTool, _ := hitchGadget.ViewTools([]catalog.Tree{
-		// refactor note: we used to stack several catalog dirs here, but have backtracked from allowing that.
-		// so it's possible there's a layer of abstraction here that should be removed outright; have not fully reviewed.
-		{ws.Layout.CatalogRoot()},
-	}...)
+func ListReleases(ctx context.Context, ws workspace.Workspace, moduleName api.ModuleName, releaseName *api.ReleaseName, itemName *api.ItemName, stdout, stderr io.Writer) error {
+	viewLineageTool, _ := hitchGadget.ViewTools(ws.CatalogTrees()...)
 
-	lineage, err := viewLineageTool(context.TODO(), moduleName)
+	lineage, err := viewLineageTool(ctx, moduleName)
 	if err != nil {
 		return err
 	}
@@ -105,7 +100,7 @@ func ListReleases(ws workspace.Workspace, moduleName api.ModuleName, releaseName
 	return nil
 }
 func UnpackCandidate(ctx context.Context, ws workspace.Workspace, sagaName catalog.SagaName, moduleName api.ModuleName, itemName api.ItemName, path string, stdout, stderr io.Writer) error {
-	tree := catalog.Tree{filepath.Join(ws.Layout.WorkspaceRoot(), ".timeless/candidates/", sagaName.String())}
+	tree := catalog.CandidateTree(ws.Layout, sagaName)
 	lineage, err := tree.LoadModuleLineage(moduleName)
 	if err != nil {
 		return err
@@ -115,33 +110,16 @@ func UnpackCandidate(ctx context.Context, ws workspace.Workspace, sagaName catal
 		return err
 	}
 
-	wareSourcing := api.WareSourcing{}
-	wareSourcing.AppendByPackType("tar", ws.Layout.StagingWarehouseLoc())
-	_, viewWarehouseTool := hitchGadget.ViewTools([]catalog.Tree{
-		// refactor note: we used to stack several catalog dirs here, but have backtracked from allowing that.
-		// so it's possible there's a layer of abstraction here that should be removed outright; have not fully reviewed.
-		{ws.Layout.CatalogRoot()},
-		{filepath.Join(ws.Layout.WorkspaceRoot(), ".timeless/candidates/", sagaName.String())},
-	}...)
-	// viewWarehouseTool = hitchGadget.WithCandidates(
-	// 	viewWarehouseTool,
-	// 	catalog.Tree{filepath.Join(ws.Layout.WorkspaceRoot(), ".timeless/candidates/", sagaName.String())},
-	// )
+	wareSourcing := ws.StagingWareSourcing()
+	_, viewWarehouseTool := hitchGadget.ViewTools(append(ws.CatalogTrees(), tree)...)
 	warehouse, err := viewWarehouseTool(ctx, moduleName)
 	wareSourcing.Append(*warehouse)
 	wareSourcing = wareSourcing.PivotToModuleWare(*wareID, moduleName)
-	warehouseLocations := []api.WarehouseLocation{
-		ws.Layout.StagingWarehouseLoc(),
-	}
-	return UnpackWareContents(ctx, ws, warehouseLocations, *wareID, path, stdout, stderr)
+	return UnpackWareContents(ctx, ws, wareSourcing.ByWare[*wareID], *wareID, path, stdout, stderr)
 }
 
 func UnpackRelease(ctx context.Context, ws workspace.Workspace, moduleName api.ModuleName, releaseName api.ReleaseName, itemName api.ItemName, path string, stdout, stderr io.Writer) error {
-	viewLineageTool, _ := hitchGadget.ViewTools([]catalog.Tree{
-		// refactor note: we used to stack several catalog dirs here, but have backtracked from allowing that.
-		// so it's possible there's a layer of abstraction here that should be removed outright; have not fully reviewed.
-		{ws.Layout.CatalogRoot()},
-	}...)
+	viewLineageTool, _ := hitchGadget.ViewTools(ws.CatalogTrees()...)
 
 	lineage, err := viewLineageTool(ctx, moduleName)
 	if err != nil {
@@ -151,13 +129,8 @@ func UnpackRelease(ctx context.Context, ws workspace.Workspace, moduleName api.M
 	if err != nil {
 		return err
 	}
-	wareSourcing := api.WareSourcing{}
-	wareSourcing.AppendByPackType("tar", ws.Layout.StagingWarehouseLoc())
-	_, viewWarehouseTool := hitchGadget.ViewTools([]catalog.Tree{
-		// refactor note: we used to stack several catalog dirs here, but have backtracked from allowing that.
-		// so it's possible there's a layer of abstraction here that should be removed outright; have not fully reviewed.
-		{ws.Layout.CatalogRoot()},
-	}...)
+	wareSourcing := ws.StagingWareSourcing()
+	_, viewWarehouseTool := hitchGadget.ViewTools(ws.CatalogTrees()...)
 	warehouse, err := viewWarehouseTool(ctx, moduleName)
 	wareSourcing.Append(*warehouse)
 	wareSourcing = wareSourcing.PivotToModuleWare(*wareID, moduleName)
@@ -166,8 +139,7 @@ func UnpackRelease(ctx context.Context, ws workspace.Workspace, moduleName api.M
 }
 
 func UnpackWareID(ctx context.Context, ws workspace.Workspace, wareId api.WareID, path string, stdout, stderr io.Writer) error {
-	wareSourcing := api.WareSourcing{}
-	wareSourcing.AppendByPackType("tar", ws.Layout.StagingWarehouseLoc())
+	wareSourcing := ws.StagingWareSourcing()
 	wareSourcing = wareSourcing.PivotToModuleWare(wareId, "")
 	return UnpackWareContents(ctx, ws, wareSourcing.ByWare[wareId], wareId, path, stdout, stderr)
 }
@@ -0,0 +1,48 @@
+package reach
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"go.polydawn.net/reach/gadgets/catalog"
+	"go.polydawn.net/reach/gadgets/workspace"
+)
+
+const sagaEnvVar = "REACH_SAGA"
+
+var sagaFlag = &cli.StringFlag{
+	Name:  "saga",
+	Usage: "name of the saga to record and read candidate releases in.  If not set, the " + sagaEnvVar + " env var is used; then the workspace config's \"saga\"; then \"default\".",
+}
+
+var noSagaFlag = &cli.BoolFlag{
+	Name:  "no-saga",
+	Usage: "if set, no candidate release will be recorded.",
+}
+
+// sagaNameFromArgs picks the saga name to use: from the flag, the env var,
+// or the workspace config, in that order of precedence, and falling back to
+// "default" if none of those are set.
+//
+// If the command has the no-saga flag and it was set, nil is returned (with no error).
+func sagaNameFromArgs(args *cli.Context, ws workspace.Workspace) (*catalog.SagaName, error) {
+	if args.Bool(noSagaFlag.Name) {
+		if args.String(sagaFlag.Name) != "" {
+			return nil, fmt.Errorf("cannot use both --%s and --%s", sagaFlag.Name, noSagaFlag.Name)
+		}
+		return nil, nil
+	}
+	s := args.String(sagaFlag.Name)
+	if s == "" {
+		s = os.Getenv(sagaEnvVar)
+	}
+	if s == "" {
+		s = ws.Config.Saga
+	}
+	if s == "" {
+		s = "default"
+	}
+	return catalog.ParseSagaName(s)
+}
@@ -9,11 +9,14 @@ import (
 	"strings"
 
 	"github.com/urfave/cli"
+	"github.com/warpfork/go-errcat"
 
 	api "go.polydawn.net/go-timeless-api"
 	catalogApp "go.polydawn.net/reach/app/catalog"
 	ciApp "go.polydawn.net/reach/app/ci"
 	emergeApp "go.polydawn.net/reach/app/emerge"
+	moduleApp "go.polydawn.net/reach/app/module"
+	sagaApp "go.polydawn.net/reach/app/saga"
 	waresApp "go.polydawn.net/reach/app/wares"
 	"go.polydawn.net/reach/gadgets/catalog"
 	"go.polydawn.net/reach/gadgets/layout"
@@ -21,6 +24,10 @@ import (
 	"go.polydawn.net/reach/gadgets/workspace"
 )
 
+// exitCodeJobsFailed is the exit code for evaluations which went as far as
+// they could (with --keep-going), but had steps fail.
+const exitCodeJobsFailed = 5
+
 func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) (exitCode int) {
 	app := &cli.App{
 		Name:  "reach",
@@ -44,14 +51,60 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 	}
 
 	app.Commands = append(app.Commands, &cli.Command{
-		Name:  "emerge",
-		Usage: "evaluate a pipeline, logging intermediate results and reporting final exports",
+		Name:      "emerge",
+		Usage:     "evaluate a pipeline, logging intermediate results and reporting final exports",
+		ArgsUsage: "[<moduleNameOrPath>...]",
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:    "recursive",
 				Aliases: []string{"r"},
 				Usage:   "if set, module evaluation will allow recursion: imports of candidate releases -- e.g., of the form \"catalog:$module:candidate:$item\" -- will cause that module to be freshly built rather than using an existing release.",
 			},
+			sagaFlag,
+			noSagaFlag,
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Value:   1,
+				Usage:   "maximum number of steps to run at once.  Steps run at the same time have their logs printed when each finishes, rather than as they go.",
+			},
+			&cli.StringFlag{
+				Name:  "log-format",
+				Value: emergeApp.LogFormatText,
+				Usage: "either \"text\", for humans; or \"json\", which emits a stream of progress events on stdout, one json object per line.",
+			},
+			&cli.BoolFlag{
+				Name:  "plan",
+				Usage: "if set, resolve imports and every step's formula, and print them (and which steps would be memoized), without running anything.  Outputs of steps which would run are shown as placeholders.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "target",
+				Usage: "evaluate only this step (e.g. \"build\" or \"submodule.step\") and the steps it depends on.  May be repeated.  No candidate release is saved.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "export",
+				Usage: "evaluate only the steps needed for this export of the module.  May be repeated.  No candidate release is saved.",
+			},
+			&cli.StringSliceFlag{
+				Name:  "pin",
+				Usage: "override an import's pin, as \"slot=wareID\" or \"slot=catalog:module:release:item\" (submodule imports are named like \"submodule.slot\").  May be repeated.  No candidate release is saved.",
+			},
+			&cli.BoolFlag{
+				Name:  "keep-going",
+				Usage: fmt.Sprintf("if set, a step failing doesn't halt evaluation: steps which don't depend on it still run, and the failures are summarized at the end.  If any steps failed, no candidate release is saved, and the exit code is %d.", exitCodeJobsFailed),
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "time limit for evaluating the whole module (e.g. \"2h\").  Defaults to the workspace config's \"timeout\", if any.",
+			},
+			&cli.DurationFlag{
+				Name:  "step-timeout",
+				Usage: "time limit for each operation (e.g. \"30m\").  An operation running out of time fails, as if it had exited non-zero.  Defaults to the workspace config's \"stepTimeout\", if any.",
+			},
+			&cli.BoolFlag{
+				Name:  "resume",
+				Usage: "if set, resume the last evaluation of the module, which failed: steps which completed then are not evaluated again.  The module and its import pins must not have changed.",
+			},
 		},
 		Action: func(args *cli.Context) error {
 			cwd, err := os.Getwd()
@@ -59,66 +112,101 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 				return err
 			}
 
-			// Parse other flags.
-			sn, _ := catalog.ParseSagaName("default") // TODO more complicated defaults and flags
-
 			// Find workspace.
 			workspaceLayout, err := layout.FindWorkspace(cwd)
 			if err != nil {
 				return err
 			}
-			workspace := workspace.Workspace{*workspaceLayout}
-
-			// Behavior switch based on whether or not recursion is allowed.
-			//  Future: unify these more...
-			if args.Bool("recursive") {
-				// Flip args into module names
-				//  FIXME: anon invocation not sensibly handled here
-				//  TODO: a lot of things not sensibly handled here...
-				//   we should probably do something not unlike what the go commands do:
-				//    - if it starts with a `./`, interpret as a path.
-				//    - if it doesn't, interpret as a module name.
-				//    - (and make some library functions that consistently do this for us.)
-				moduleNames := []api.ModuleName(nil)
-				for _, arg := range args.Args().Slice() {
-					moduleNames = append(moduleNames, api.ModuleName(arg))
-				}
+			ws, err := workspace.Load(*workspaceLayout)
+			if err != nil {
+				return err
+			}
 
-				// Go!
-				return emergeApp.EmergeMulti(workspace, moduleNames, *sn, stdout, stderr)
-			} else {
-				// Find (or expect) module (depending on args style).
-				//  The arg is expected to be a *path* (not a module name
-				//   (although the two are currently the same in practice)).
-				var moduleLayout *layout.Module
-				switch args.NArg() {
-				case 0:
-					moduleLayout, err = layout.FindModule(*workspaceLayout, cwd)
-				case 1:
-					// TODO: difference between moduleName and path also should be considered here (see other TODOs above).
-					moduleLayout, err = layout.ExpectModule(*workspaceLayout, filepath.Join(cwd, args.Args().First()))
-				default:
-					return fmt.Errorf("'reach emerge' takes zero or one args")
-				}
+			// Parse other flags.
+			sn, err := sagaNameFromArgs(args, *ws)
+			if err != nil {
+				return err
+			}
+			if args.Int("jobs") < 1 {
+				return fmt.Errorf("--jobs must be at least 1")
+			}
+			opts := emergeApp.Options{
+				Parallelism: args.Int("jobs"),
+				LogFormat:   args.String("log-format"),
+				Plan:        args.Bool("plan"),
+				KeepGoing:   args.Bool("keep-going"),
+				Resume:      args.Bool("resume"),
+				Timeout:     args.Duration("timeout"),
+				StepTimeout: args.Duration("step-timeout"),
+			}
+			if opts.Timeout < 0 || opts.StepTimeout < 0 {
+				return fmt.Errorf("--timeout and --step-timeout cannot be negative")
+			}
+			for _, target := range args.StringSlice("target") {
+				opts.TargetSteps = append(opts.TargetSteps, module.ParseStepRef(target))
+			}
+			for _, export := range args.StringSlice("export") {
+				opts.TargetExports = append(opts.TargetExports, api.ItemName(export))
+			}
+			for _, pin := range args.StringSlice("pin") {
+				override, err := emergeApp.ParsePinOverride(pin)
 				if err != nil {
-					return err // oh no
+					return err
 				}
+				opts.PinOverrides = append(opts.PinOverrides, *override)
+			}
+			if opts.Plan && opts.LogFormat == emergeApp.LogFormatJson {
+				return fmt.Errorf("--plan prints the plan as json already; cannot use --log-format=json")
+			}
 
-				// Load module.
-				mod, err := module.Load(*moduleLayout)
-				if err != nil {
-					return fmt.Errorf("error loading module: %s", err)
+			// Interpret args: they may be module names, paths, or patterns.
+			modRefs, err := ResolveModuleArgs(*ws, args.Args().Slice(), cwd)
+			if err != nil {
+				return err
+			}
+
+			// If there are several modules, or recursion is allowed,
+			//  we'll need to work out a commission order and go from there.
+			if args.Bool("recursive") || len(modRefs) > 1 {
+				if sn == nil {
+					return fmt.Errorf("evaluating several modules together requires a saga; cannot use --%s", noSagaFlag.Name)
 				}
+				if opts.Plan {
+					return fmt.Errorf("--plan can only be used with one module at a time")
+				}
+				if len(opts.TargetSteps) > 0 || len(opts.TargetExports) > 0 {
+					return fmt.Errorf("--target and --export can only be used with one module at a time")
+				}
+				if len(opts.PinOverrides) > 0 {
+					return fmt.Errorf("--pin can only be used with one module at a time")
+				}
+				if opts.Resume {
+					return fmt.Errorf("--resume can only be used with one module at a time")
+				}
+				return emergeApp.EmergeMulti(ctx, *ws, ModuleNames(modRefs), args.Bool("recursive"), *sn, opts, stdout, stderr)
+			}
 
-				// Go!
-				return emergeApp.EvalModule(workspace, *moduleLayout, sn, *mod, stdout, stderr)
+			// Otherwise, exactly one module: simple.
+			modRef := modRefs[0]
+			if modRef.Layout == nil {
+				return fmt.Errorf("module %q is not mapped to any path by the workspace config", modRef.Name)
 			}
+
+			// Load module.
+			mod, err := module.Load(*modRef.Layout)
+			if err != nil {
+				return fmt.Errorf("error loading module: %s", err)
+			}
+
+			// Go!
+			return emergeApp.EvalModule(ctx, *ws, *modRef.Layout, sn, *mod, opts, stdout, stderr)
 		},
 	})
 
 	app.Commands = append(app.Commands, &cli.Command{
-		Name:  "ci",
-		Usage: "given a module with one ingest using git, build it once, then build it again each time the git repo updates",
+		Name:      "ci",
+		Usage:     "given a module with one ingest using git, build it once, then build it again each time the git repo updates",
+		ArgsUsage: "[<moduleNameOrPath>]",
 		Action: func(args *cli.Context) error {
 			cwd, err := os.Getwd()
 			if err != nil {
@@ -130,29 +218,135 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 			if err != nil {
 				return err
 			}
+			ws, err := workspace.Load(*workspaceLayout)
+			if err != nil {
+				return err
+			}
 
 			// Find (or expect) module (depending on args style).
-			var moduleLayout *layout.Module
+			var modRef *ModuleRef
 			switch args.NArg() {
-			case 0:
-				moduleLayout, err = layout.FindModule(*workspaceLayout, cwd)
-			case 1:
-				moduleLayout, err = layout.ExpectModule(*workspaceLayout, filepath.Join(cwd, args.Args().First()))
+			case 0, 1:
+				modRef, err = ResolveModuleArg(*ws, args.Args().First(), cwd)
 			default:
 				return fmt.Errorf("'reach ci' takes zero or one args")
 			}
 			if err != nil {
 				return err
 			}
+			if modRef.Layout == nil {
+				return fmt.Errorf("module %q is not mapped to any path by the workspace config", modRef.Name)
+			}
 
-			// Load module and load workspace conf.
-			mod, err := module.Load(*moduleLayout)
+			// Load module.
+			mod, err := module.Load(*modRef.Layout)
 			if err != nil {
 				return fmt.Errorf("error loading module: %s", err)
 			}
-			workspace := workspace.Workspace{*workspaceLayout}
 
-			return ciApp.Loop(workspace, *moduleLayout, *mod, stdout, stderr)
+			return ciApp.Loop(ctx, *ws, *modRef.Layout, *mod, stdout, stderr)
+		},
+	})
+
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:  "formulas",
+		Usage: "formulas subcommands show the formulas a module's steps resolve to",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "export",
+				Usage:     "write each step's resolved formula and formula context into a dir, one json file per step, so they can be run (or edited and run) with repeatr directly.  Nothing is evaluated: steps downstream of steps which have not been run yet will have placeholder inputs.",
+				ArgsUsage: "<moduleNameOrPath> <dir>",
+				Flags: []cli.Flag{
+					sagaFlag,
+					noSagaFlag,
+				},
+				Action: func(args *cli.Context) error {
+					cwd, err := os.Getwd()
+					if err != nil {
+						return err
+					}
+					if args.NArg() != 2 {
+						return fmt.Errorf("'reach formulas export' takes exactly two args")
+					}
+					workspaceLayout, err := layout.FindWorkspace(cwd)
+					if err != nil {
+						return err
+					}
+					ws, err := workspace.Load(*workspaceLayout)
+					if err != nil {
+						return err
+					}
+					sn, err := sagaNameFromArgs(args, *ws)
+					if err != nil {
+						return err
+					}
+					modRef, err := ResolveModuleArg(*ws, args.Args().Get(0), cwd)
+					if err != nil {
+						return err
+					}
+					if modRef.Layout == nil {
+						return fmt.Errorf("module %q is not mapped to any path by the workspace config", modRef.Name)
+					}
+					mod, err := module.Load(*modRef.Layout)
+					if err != nil {
+						return fmt.Errorf("error loading module: %s", err)
+					}
+					return emergeApp.ExportFormulas(ctx, *ws, *modRef.Layout, sn, *mod, absPath(args.Args().Get(1), cwd), stdout, stderr)
+				},
+			},
+		},
+	})
+
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:  "module",
+		Usage: "module subcommands help maintain modules",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "check",
+				Usage:     "check a module for mistakes without evaluating it: references to slots that aren't in scope, colliding input or output paths, catalog imports that don't exist, and dependency cycles.  Every problem is listed on stdout, by the step it's in; the exit code is non-zero if there are any.",
+				ArgsUsage: "[<moduleNameOrPath>]",
+				Flags: []cli.Flag{
+					sagaFlag,
+					noSagaFlag,
+					&cli.BoolFlag{
+						Name:  "unused",
+						Usage: "if set, also list imports nothing uses, step outputs nothing reads or exports, and steps which contribute to no export (only if there are no other problems)",
+					},
+				},
+				Action: func(args *cli.Context) error {
+					cwd, err := os.Getwd()
+					if err != nil {
+						return err
+					}
+					if args.NArg() > 1 {
+						return fmt.Errorf("'reach module check' takes zero or one args")
+					}
+					workspaceLayout, err := layout.FindWorkspace(cwd)
+					if err != nil {
+						return err
+					}
+					ws, err := workspace.Load(*workspaceLayout)
+					if err != nil {
+						return err
+					}
+					sn, err := sagaNameFromArgs(args, *ws)
+					if err != nil {
+						return err
+					}
+					modRef, err := ResolveModuleArg(*ws, args.Args().First(), cwd)
+					if err != nil {
+						return err
+					}
+					if modRef.Layout == nil {
+						return fmt.Errorf("module %q is not mapped to any path by the workspace config", modRef.Name)
+					}
+					mod, err := module.Load(*modRef.Layout)
+					if err != nil {
+						return fmt.Errorf("error loading module: %s", err)
+					}
+					return moduleApp.Check(ctx, *ws, sn, *mod, args.Bool("unused"), stdout, stderr)
+				},
+			},
 		},
 	})
 
@@ -208,6 +402,26 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 					return err
 				},
 			},
+			{
+				Name:      "verify-replay",
+				Usage:     "rebuild a release from its replay instructions, and check the results match the catalog (report is emitted as json on stdout)",
+				ArgsUsage: "<moduleName> <releaseName>",
+				Action: func(args *cli.Context) error {
+					ws, err := findWorkspace()
+					if err != nil {
+						return err
+					}
+					if args.NArg() != 2 {
+						return fmt.Errorf("'reach catalog verify-replay' takes exactly two args")
+					}
+					modName := api.ModuleName(args.Args().Get(0))
+					if err := modName.Validate(); err != nil {
+						return err
+					}
+					relName := api.ReleaseName(args.Args().Get(1))
+					return catalogApp.VerifyReplay(ctx, *ws, modName, relName, stdout, stderr)
+				},
+			},
 		},
 	})
 
@@ -223,22 +437,31 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 						Name:      "candidates",
 						Usage:     "List release candidates",
 						ArgsUsage: "[<moduleNameOrPath> [<item-name>]]",
+						Flags: []cli.Flag{
+							sagaFlag,
+						},
 						Action: func(args *cli.Context) error {
 							cwd, err := os.Getwd()
 							if err != nil {
 								return err
 							}
 
-							// Parse other flags.
-							sn, _ := catalog.ParseSagaName("default") // TODO more complicated defaults and flags
-
 							// Find workspace.
 							workspaceLayout, err := layout.FindWorkspace(cwd)
 							if err != nil {
 								return err
 							}
 
-							ws := workspace.Workspace{*workspaceLayout}
+							ws, err := workspace.Load(*workspaceLayout)
+							if err != nil {
+								return err
+							}
+
+							// Parse other flags.
+							sn, err := sagaNameFromArgs(args, *ws)
+							if err != nil {
+								return err
+							}
 
 							var itemName *api.ItemName
 							var modNameOrPath string
@@ -253,15 +476,16 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 								modNameOrPath = args.Args().Slice()[0]
 								fallthrough
 							case 0:
-								modName, err = ModuleNameOrPath(ws, modNameOrPath, cwd)
+								modRef, err := ResolveModuleArg(*ws, modNameOrPath, cwd)
 								if err != nil {
 									return err
 								}
+								modName = &modRef.Name
 							default:
 								return fmt.Errorf("select takes 0 or 1 item name")
 							}
 
-							return waresApp.ListCandidates(ws, *modName, *sn, itemName, stdout, stderr)
+							return waresApp.ListCandidates(*ws, *modName, *sn, itemName, stdout, stderr)
 						},
 					},
 					{
@@ -280,7 +504,10 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 								return err
 							}
 
-							workspace := workspace.Workspace{*workspaceLayout}
+							ws, err := workspace.Load(*workspaceLayout)
+							if err != nil {
+								return err
+							}
 							var modName *api.ModuleName
 							var releaseName *api.ReleaseName
 							var itemName *api.ItemName
@@ -298,14 +525,15 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 								modNameStr = args.Args().Slice()[0]
 								fallthrough
 							case 0:
-								modName, err = ModuleNameOrPath(workspace, modNameStr, cwd)
+								modRef, err := ResolveModuleArg(*ws, modNameStr, cwd)
 								if err != nil {
 									return err
 								}
+								modName = &modRef.Name
 							default:
 								return fmt.Errorf("select takes 0 or 1 item name")
 							}
-							return waresApp.ListReleases(workspace, *modName, releaseName, itemName, stdout, stderr)
+							return waresApp.ListReleases(ctx, *ws, *modName, releaseName, itemName, stdout, stderr)
 						},
 					},
 				},
@@ -351,19 +579,25 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 								return err
 							}
 
-							workspace := workspace.Workspace{*workspaceLayout}
+							ws, err := workspace.Load(*workspaceLayout)
+							if err != nil {
+								return err
+							}
 							unpackPath := filepath.Join(cwd, unpackDir)
 							err = os.MkdirAll(unpackPath, 0644)
 							if err != nil {
 								return err
 							}
-							return waresApp.UnpackWareID(ctx, workspace, wareId, unpackPath, stdout, stderr)
+							return waresApp.UnpackWareID(ctx, *ws, wareId, unpackPath, stdout, stderr)
 						},
 					},
 					{
 						Name:      "candidate",
 						Usage:     "Unpack a release candidate",
 						ArgsUsage: "<moduleNameOrPath> <itemName> [<outputPath>]",
+						Flags: []cli.Flag{
+							sagaFlag,
+						},
 						Action: func(args *cli.Context) error {
 							unpackDir := "tmp.unpack"
 							cwd, err := os.Getwd()
@@ -376,19 +610,25 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 							if err != nil {
 								return err
 							}
-							sn, _ := catalog.ParseSagaName("default") // TODO more complicated defaults and flags
-							ws := workspace.Workspace{*workspaceLayout}
+							ws, err := workspace.Load(*workspaceLayout)
+							if err != nil {
+								return err
+							}
+							sn, err := sagaNameFromArgs(args, *ws)
+							if err != nil {
+								return err
+							}
 							switch args.NArg() {
 							case 3:
 								unpackDir = args.Args().Slice()[2]
 								fallthrough
 							case 2:
-								modName, err := ModuleNameOrPath(ws, args.Args().Slice()[0], cwd)
+								modRef, err := ResolveModuleArg(*ws, args.Args().Slice()[0], cwd)
 								if err != nil {
 									return err
 								}
 								itemName := api.ItemName(args.Args().Slice()[1])
-								return waresApp.UnpackCandidate(ctx, ws, *sn, *modName, itemName, unpackDir, stdout, stderr)
+								return waresApp.UnpackCandidate(ctx, *ws, *sn, modRef.Name, itemName, unpackDir, stdout, stderr)
 							default:
 								return fmt.Errorf("'unpack candidate' takes either 2 or 3 arguments.  See -h for details.")
 							}
@@ -410,19 +650,22 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 							if err != nil {
 								return err
 							}
-							ws := workspace.Workspace{*workspaceLayout}
+							ws, err := workspace.Load(*workspaceLayout)
+							if err != nil {
+								return err
+							}
 							switch args.NArg() {
 							case 4:
 								unpackDir = args.Args().Slice()[3]
 								fallthrough
 							case 3:
-								modName, err := ModuleNameOrPath(ws, args.Args().Slice()[0], cwd)
+								modRef, err := ResolveModuleArg(*ws, args.Args().Slice()[0], cwd)
 								if err != nil {
 									return err
 								}
 								releaseName := api.ReleaseName(args.Args().Slice()[1])
 								itemName := api.ItemName(args.Args().Slice()[2])
-								return waresApp.UnpackRelease(ctx, ws, *modName, releaseName, itemName, unpackDir, stdout, stderr)
+								return waresApp.UnpackRelease(ctx, *ws, modRef.Name, releaseName, itemName, unpackDir, stdout, stderr)
 							default:
 								return fmt.Errorf("'unpack release' takes either 3 or 4 arguments.  See -h for details.")
 							}
@@ -433,6 +676,106 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 		},
 	})
 
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:  "saga",
+		Usage: "manage sagas: sets of candidate releases, which can be committed to the catalog together",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "list all sagas in the workspace",
+				Action: func(args *cli.Context) error {
+					ws, err := findWorkspace()
+					if err != nil {
+						return err
+					}
+					if args.NArg() != 0 {
+						return fmt.Errorf("'reach saga list' takes no args")
+					}
+					return sagaApp.List(*ws, stdout, stderr)
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "list the candidate releases in a saga",
+				ArgsUsage: "[<sagaName>]",
+				Flags: []cli.Flag{
+					sagaFlag,
+				},
+				Action: func(args *cli.Context) error {
+					ws, err := findWorkspace()
+					if err != nil {
+						return err
+					}
+					var sn *catalog.SagaName
+					switch args.NArg() {
+					case 0:
+						sn, err = sagaNameFromArgs(args, *ws)
+					case 1:
+						sn, err = catalog.ParseSagaName(args.Args().First())
+					default:
+						return fmt.Errorf("'reach saga show' takes zero or one args")
+					}
+					if err != nil {
+						return err
+					}
+					return sagaApp.Show(*ws, *sn, stdout, stderr)
+				},
+			},
+			{
+				Name:      "discard",
+				Usage:     "remove a saga and all its candidate releases",
+				ArgsUsage: "<sagaName>",
+				Action: func(args *cli.Context) error {
+					ws, err := findWorkspace()
+					if err != nil {
+						return err
+					}
+					if args.NArg() != 1 {
+						return fmt.Errorf("'reach saga discard' takes exactly one arg")
+					}
+					sn, err := catalog.ParseSagaName(args.Args().First())
+					if err != nil {
+						return err
+					}
+					return sagaApp.Discard(*ws, *sn, stdout, stderr)
+				},
+			},
+			{
+				Name:      "commit",
+				Usage:     "promote every candidate release in a saga to a named release in the workspace catalog",
+				ArgsUsage: "<sagaName> <releaseName>",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "mirror",
+						Usage: "warehouse to list in the mirrors file for the new wares (may be repeated).  Default is the workspace's staging warehouse.",
+					},
+				},
+				Action: func(args *cli.Context) error {
+					ws, err := findWorkspace()
+					if err != nil {
+						return err
+					}
+					if args.NArg() != 2 {
+						return fmt.Errorf("'reach saga commit' takes exactly two args")
+					}
+					sn, err := catalog.ParseSagaName(args.Args().Get(0))
+					if err != nil {
+						return err
+					}
+					releaseName := api.ReleaseName(args.Args().Get(1))
+					mirrors := []api.WarehouseLocation{}
+					for _, mirror := range args.StringSlice("mirror") {
+						mirrors = append(mirrors, api.WarehouseLocation(mirror))
+					}
+					if len(mirrors) == 0 {
+						mirrors = append(mirrors, ws.StagingWarehouseLoc())
+					}
+					return sagaApp.Commit(*ws, *sn, releaseName, mirrors, stdout, stderr)
+				},
+			},
+		},
+	})
+
 	app.Commands = append(app.Commands, &cli.Command{
 		Name:  "synopsis",
 		Usage: "list every command and subcommand, for quick reference",
@@ -444,6 +787,9 @@ func Main(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io
 
 	if err := app.Run(args); err != nil {
 		exitCode = 1
+		if errcat.Category(err) == emergeApp.ErrJobsFailed {
+			exitCode = exitCodeJobsFailed
+		}
 		fmt.Fprintf(stderr, "reach: %s\n", err)
 	}
 	return
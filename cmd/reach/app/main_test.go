@@ -30,8 +30,11 @@ func TestNoargsHelptext(t *testing.T) {
 		COMMANDS:
 		   emerge    evaluate a pipeline, logging intermediate results and reporting final exports
 		   ci        given a module with one ingest using git, build it once, then build it again each time the git repo updates
+		   formulas  formulas subcommands show the formulas a module's steps resolve to
+		   module    module subcommands help maintain modules
 		   catalog   catalog subcommands help maintain the release catalog info tree
 		   wares     look up wares by release or candidate
+		   saga      manage sagas: sets of candidate releases, which can be committed to the catalog together
 		   synopsis  list every command and subcommand, for quick reference
 		   help, h   Shows a list of commands or help for one command
 
@@ -1,36 +1,161 @@
 package reach
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"go.polydawn.net/go-timeless-api"
 	"go.polydawn.net/reach/gadgets/layout"
 	"go.polydawn.net/reach/gadgets/workspace"
-	"path/filepath"
 )
 
-func ModuleNameOrPath(ws workspace.Workspace, modNameOrPath, curDir string) (*api.ModuleName, error) {
-	if layout.IsModuleName(modNameOrPath) {
-		modName := api.ModuleName(modNameOrPath)
-		if err := modName.Validate(); err != nil {
+// findWorkspace finds the workspace containing the cwd, and loads its config.
+func findWorkspace() (*workspace.Workspace, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	workspaceLayout, err := layout.FindWorkspace(cwd)
+	if err != nil {
+		return nil, err
+	}
+	return workspace.Load(*workspaceLayout)
+}
+
+// ModuleRef is the result of interpreting a CLI arg that refers to a module.
+//
+// Name is always set.
+// Layout is set if the module is in the workspace -- it's always set if the
+// arg was a path; if the arg was a name, it's set only if the workspace config
+// maps that name to a path (which doesn't mean there's a module there, either;
+// names can just as well refer to modules which are only in a catalog).
+type ModuleRef struct {
+	Name   api.ModuleName
+	Layout *layout.Module
+}
+
+// ResolveModuleArgs interprets CLI args that refer to modules.
+// Every subcommand that takes modules as args should use this
+// (or ResolveModuleArg), so they all behave the same.
+//
+// Args are interpreted much like the go command does:
+//
+//   - if an arg starts with "." or "/", it's a path (see layout.IsModuleName),
+//     and there must be a module at exactly that path;
+//   - if an arg is a path ending in "/...", it's a pattern, and means
+//     every module beneath that path (see IsModulePattern);
+//   - otherwise, the arg is a module name.
+//
+// Relative paths are relative to curDir.
+// If there are no args at all, the module is found by searching up from curDir.
+//
+// Results are in the order of the args; a module referred to more than once
+// only appears in the results the first time.
+func ResolveModuleArgs(ws workspace.Workspace, args []string, curDir string) ([]ModuleRef, error) {
+	if len(args) == 0 {
+		module, err := layout.FindModule(ws.Layout, curDir)
+		if err != nil {
 			return nil, err
 		}
-		return &modName, nil
-	} else {
-		var module *layout.Module
-		var err error
-		if modNameOrPath == "" {
-			module, err = layout.FindModule(ws.Layout, curDir)
-		} else {
-			modulePath := filepath.Join(curDir, modNameOrPath)
-			module, err = layout.ExpectModule(ws.Layout, modulePath)
+		ref, err := moduleRefForLayout(ws, *module)
+		if err != nil {
+			return nil, err
+		}
+		return []ModuleRef{*ref}, nil
+	}
+
+	refs := []ModuleRef{}
+	seen := map[api.ModuleName]struct{}{}
+	add := func(ref ModuleRef) {
+		if _, ok := seen[ref.Name]; ok {
+			return
+		}
+		seen[ref.Name] = struct{}{}
+		refs = append(refs, ref)
+	}
+	for _, arg := range args {
+		switch {
+		case IsModulePattern(arg):
+			modules, err := layout.FindModulesBeneath(ws.Layout, absPath(strings.TrimSuffix(arg, "..."), curDir))
 			if err != nil {
 				return nil, err
 			}
+			if len(modules) == 0 {
+				return nil, fmt.Errorf("no modules found matching %q", arg)
+			}
+			for _, module := range modules {
+				ref, err := moduleRefForLayout(ws, module)
+				if err != nil {
+					return nil, err
+				}
+				add(*ref)
+			}
+		case !layout.IsModuleName(arg):
+			module, err := layout.ExpectModule(ws.Layout, absPath(arg, curDir))
+			if err != nil {
+				return nil, err
+			}
+			ref, err := moduleRefForLayout(ws, *module)
+			if err != nil {
+				return nil, err
+			}
+			add(*ref)
+		default:
+			modName := api.ModuleName(arg)
+			if err := modName.Validate(); err != nil {
+				return nil, err
+			}
+			add(ModuleRef{modName, ws.GetModuleLayout(modName)})
 		}
-		modName, err := ws.ResolveModuleName(*module)
-		if err != nil {
-			return nil, err
-		}
+	}
+	return refs, nil
+}
+
+// ResolveModuleArg is ResolveModuleArgs for subcommands that take exactly
+// one module.  An empty string means "no arg" (search from curDir).
+func ResolveModuleArg(ws workspace.Workspace, arg string, curDir string) (*ModuleRef, error) {
+	args := []string{arg}
+	if arg == "" {
+		args = nil
+	}
+	refs, err := ResolveModuleArgs(ws, args, curDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) != 1 {
+		return nil, fmt.Errorf("%q matches %d modules, but only one module can be used here", arg, len(refs))
+	}
+	return &refs[0], nil
+}
+
+// ModuleNames returns just the names from a list of ModuleRef.
+func ModuleNames(refs []ModuleRef) []api.ModuleName {
+	modNames := make([]api.ModuleName, len(refs))
+	for i, ref := range refs {
+		modNames[i] = ref.Name
+	}
+	return modNames
+}
+
+// IsModulePattern returns true if the string is a path ending in "...",
+// e.g. "./group/...", which means "every module beneath this dir".
+func IsModulePattern(str string) bool {
+	return str == "..." || strings.HasSuffix(str, "/...")
+}
+
+func moduleRefForLayout(ws workspace.Workspace, module layout.Module) (*ModuleRef, error) {
+	modName, err := ws.ResolveModuleName(module)
+	if err != nil {
+		return nil, err
+	}
+	return &ModuleRef{modName, &module}, nil
+}
 
-		return &modName, nil
+func absPath(pth, curDir string) string {
+	if filepath.IsAbs(pth) {
+		return filepath.Clean(pth)
 	}
+	return filepath.Join(curDir, pth)
 }
@@ -2,11 +2,27 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	reach "go.polydawn.net/reach/cmd/reach/app"
 )
 
 func main() {
-	os.Exit(reach.Main(context.Background(), os.Args, os.Stdin, os.Stdout, os.Stderr))
+	// Cancel the context on SIGINT or SIGTERM, so whatever's running can
+	//  stop its work (including any repeatr processes) and say what it
+	//  was doing.  A second signal means stop *now*.
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		fmt.Fprintf(os.Stderr, "reach: received %s, stopping...  (signal again to exit immediately)\n", sig)
+		cancel()
+		<-sigCh
+		os.Exit(130)
+	}()
+	os.Exit(reach.Main(ctx, os.Args, os.Stdin, os.Stdout, os.Stderr))
 }
@@ -0,0 +1,100 @@
+package failingmodule
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/warpfork/go-wish"
+
+	. "go.polydawn.net/reach/examples/testutil"
+)
+
+func Test(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		t.Run("without keep-going, the failure halts evaluation", func(t *testing.T) {
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, stdout, ShouldEqual, "")
+			Wish(t, strings.Contains(stderr, `exit code 3 -- eval halted`), ShouldEqual, true)
+		})
+		t.Run("with keep-going, independent steps still run", func(t *testing.T) {
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "--keep-going")
+			stderr, _ = SplitStepLogs(stderr)
+			Wish(t, exitCode, ShouldEqual, 5)
+			Wish(t, strings.Contains(stdout, `"package": "tar:`), ShouldEqual, true)
+			Wish(t, strings.Contains(stdout, `"report"`), ShouldEqual, false)
+			Wish(t, strings.Contains(stderr, Dedent(`
+				module eval completed with failures.
+				failed steps:
+				  - test: exit code 3
+				skipped steps, because they depend on failed steps:
+				  - publish-report
+			`)), ShouldEqual, true)
+		})
+	})
+}
+
+func TestResume(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		t.Run("with nothing to resume, resuming should fail", func(t *testing.T) {
+			exitCode, _, stderr := RunIntoBuffer("reach", "emerge", "--resume")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, strings.Contains(stderr, "there's no failed evaluation of module"), ShouldEqual, true)
+		})
+		t.Run("resuming a failed evaluation should reuse the completed steps", func(t *testing.T) {
+			exitCode, _, _ := RunIntoBuffer("reach", "emerge")
+			Wish(t, exitCode, ShouldEqual, 1)
+			exitCode, _, stderr := RunIntoBuffer("reach", "emerge", "--resume")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, strings.Contains(stderr, "build already completed, reusing its results"), ShouldEqual, true)
+			Wish(t, strings.Contains(stderr, "exit code 3 -- eval halted"), ShouldEqual, true)
+
+			// The run report should count the reused step as completed.
+			reports, err := filepath.Glob(".timeless/reports/*.json")
+			Wish(t, err, ShouldEqual, nil)
+			sort.Strings(reports)
+			content, err := ioutil.ReadFile(reports[len(reports)-1])
+			Wish(t, err, ShouldEqual, nil)
+			var report struct {
+				Steps map[string]struct {
+					Reused bool
+					Record struct {
+						Results map[string]string
+					}
+				}
+			}
+			Wish(t, json.Unmarshal(content, &report), ShouldEqual, nil)
+			Wish(t, report.Steps["build"].Reused, ShouldEqual, true)
+			Wish(t, len(report.Steps["build"].Record.Results) > 0, ShouldEqual, true)
+		})
+		t.Run("resuming after the output config changed should fail", func(t *testing.T) {
+			Wish(t, ioutil.WriteFile(".timeless/workspace.tl", []byte(`{"output": {"mtime": "@0"}}`), 0644), ShouldEqual, nil)
+			defer os.Remove(".timeless/workspace.tl")
+			exitCode, _, stderr := RunIntoBuffer("reach", "emerge", "--resume")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, strings.Contains(stderr, "outputs are packed has changed"), ShouldEqual, true)
+		})
+	})
+}
+
+func TestStepTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		exitCode, _, stderr := RunIntoBuffer("reach", "emerge", "--step-timeout", "1ms")
+		Wish(t, exitCode, ShouldEqual, 1)
+		Wish(t, strings.Contains(stderr, `operation "build" timed out after 1ms`), ShouldEqual, true)
+	})
+}
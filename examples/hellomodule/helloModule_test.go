@@ -1,6 +1,10 @@
 package hellomodule
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	. "github.com/warpfork/go-wish"
@@ -14,6 +18,7 @@ func Test(t *testing.T) {
 	}
 	WithCwdClonedTmpDir(GetCwdAbs(), func() {
 		exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge")
+		stderr, stepLogs := SplitStepLogs(stderr)
 		Wish(t, exitCode, ShouldEqual, 0)
 		Wish(t, stderr, ShouldEqual, Dedent(`
 			module loaded
@@ -30,6 +35,100 @@ func Test(t *testing.T) {
 				"wowslot": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv"
 			}
 		`))
+
+		t.Run("step logs should be reported", func(t *testing.T) {
+			Wish(t, strings.HasPrefix(stepLogs, "beginning evaluation of step : main\n"), ShouldEqual, true)
+			Wish(t, strings.Contains(stepLogs, "step main: resolving..."), ShouldEqual, true)
+			Wish(t, strings.Contains(stepLogs, "step main: repeatr'ing..."), ShouldEqual, true)
+		})
+	})
+}
+
+func TestJsonLog(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "--no-saga", "--log-format=json")
+		Wish(t, exitCode, ShouldEqual, 0)
+		Wish(t, stderr, ShouldEqual, "")
+		// Every line should be an event.  Times vary, so pick out just the parts we can check.
+		kinds := []string{}
+		var last map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+			var evt map[string]interface{}
+			Wish(t, json.Unmarshal([]byte(line), &evt), ShouldEqual, nil)
+			if evt["event"] == "step-output" {
+				continue // how much of this there is is up to repeatr.
+			}
+			kinds = append(kinds, evt["event"].(string))
+			last = evt
+		}
+		Wish(t, kinds, ShouldEqual, []string{
+			"plan",
+			"import-pinned",
+			"step-resolved",
+			"step-started",
+			"step-finished",
+			"exports",
+		})
+		Wish(t, last["exports"], ShouldEqual, map[string]interface{}{
+			"wowslot": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv",
+		})
+	})
+}
+
+func TestPlan(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		plan := func() []map[string]interface{} {
+			exitCode, stdout, _ := RunIntoBuffer("reach", "emerge", "--plan")
+			Wish(t, exitCode, ShouldEqual, 0)
+			var steps []map[string]interface{}
+			Wish(t, json.Unmarshal([]byte(stdout), &steps), ShouldEqual, nil)
+			return steps
+		}
+		t.Run("before running, the step should be planned to run", func(t *testing.T) {
+			steps := plan()
+			Wish(t, len(steps), ShouldEqual, 1)
+			Wish(t, steps[0]["step"], ShouldEqual, "main")
+			Wish(t, steps[0]["memoized"], ShouldEqual, false)
+		})
+		t.Run("after running, the step should be memoized", func(t *testing.T) {
+			exitCode, _, _ := RunIntoBuffer("reach", "emerge")
+			Wish(t, exitCode, ShouldEqual, 0)
+			steps := plan()
+			Wish(t, len(steps), ShouldEqual, 1)
+			Wish(t, steps[0]["memoized"], ShouldEqual, true)
+		})
+	})
+}
+
+func TestFormulasExport(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		exitCode, stdout, _ := RunIntoBuffer("reach", "formulas", "export", ".", "formulas")
+		Wish(t, exitCode, ShouldEqual, 0)
+		Wish(t, stdout, ShouldEqual, filepath.Join(GetCwdAbs(), "formulas", "main.formula.json")+"\n")
+		content, err := ioutil.ReadFile(filepath.Join("formulas", "main.formula.json"))
+		Wish(t, err, ShouldEqual, nil)
+		var exported struct {
+			Formula struct {
+				Inputs  map[string]string
+				Outputs map[string]interface{}
+			}
+			Context struct {
+				FetchUrls map[string][]string
+				SaveUrls  map[string]string
+			}
+		}
+		Wish(t, json.Unmarshal(content, &exported), ShouldEqual, nil)
+		Wish(t, len(exported.Formula.Inputs), ShouldEqual, len(exported.Context.FetchUrls))
+		Wish(t, len(exported.Formula.Outputs), ShouldEqual, len(exported.Context.SaveUrls))
 	})
 }
 
@@ -41,3 +140,46 @@ func TestLint(t *testing.T) {
 		0 total warnings
 	`))
 }
+
+func TestModuleCheck(t *testing.T) {
+	t.Run("the module should check out fine", func(t *testing.T) {
+		exitCode, stdout, stderr := RunIntoBuffer("reach", "module", "check", "--no-saga")
+		Wish(t, exitCode, ShouldEqual, 0)
+		Wish(t, stdout, ShouldEqual, "")
+		Wish(t, stderr, ShouldEqual, "module ok\n")
+	})
+	t.Run("with --unused, an unused import should be listed", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			content, err := ioutil.ReadFile("module.tl")
+			Wish(t, err, ShouldEqual, nil)
+			content = []byte(strings.Replace(string(content), `"imports": {`, `"imports": {
+		"spare": "catalog:froob.org/base:v1:linux-amd64",`, 1))
+			Wish(t, ioutil.WriteFile("module.tl", content, 0644), ShouldEqual, nil)
+			exitCode, stdout, _ := RunIntoBuffer("reach", "module", "check", "--no-saga")
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stdout, ShouldEqual, "")
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "module", "check", "--no-saga", "--unused")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, stdout, ShouldEqual, Dedent(`
+				module: import "spare" is not used
+			`))
+			Wish(t, stderr, ShouldEqual, "reach: module has 1 problems\n")
+		})
+	})
+	t.Run("every mistake should be listed", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			content, err := ioutil.ReadFile("module.tl")
+			Wish(t, err, ShouldEqual, nil)
+			content = []byte(strings.Replace(string(content), `"wowslot": "main.out"`, `"wowslot": "main.output"`, 1))
+			content = []byte(strings.Replace(string(content), `linux-amd64`, `linux-arm64`, 1))
+			Wish(t, ioutil.WriteFile("module.tl", content, 0644), ShouldEqual, nil)
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "module", "check", "--no-saga")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, stdout, ShouldEqual, Dedent(`
+				module: import "base": release froob.org/base:v1 has no item "linux-arm64"
+				module: export "wowslot": "main.output": step "main" has no output "output"
+			`))
+			Wish(t, stderr, ShouldEqual, "reach: module has 2 problems\n")
+		})
+	})
+}
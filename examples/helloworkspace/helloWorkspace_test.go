@@ -1,7 +1,11 @@
 package helloworkspace
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	. "github.com/warpfork/go-wish"
@@ -36,6 +40,7 @@ func TestEmergeInModule(t *testing.T) {
 	WithCwdClonedTmpDir(GetCwdAbs(), func() {
 		os.Chdir("example.org/proj-foo")
 		exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge")
+		stderr, _ = SplitStepLogs(stderr)
 		Wish(t, exitCode, ShouldEqual, 0)
 		Wish(t, stderr, ShouldEqual, Dedent(`
 			module loaded
@@ -61,6 +66,7 @@ func TestEmergeInModule(t *testing.T) {
 			t.Run("another module can consume the candidate", func(t *testing.T) {
 				os.Chdir("../proj-bar")
 				exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge")
+				stderr, _ = SplitStepLogs(stderr)
 				Wish(t, exitCode, ShouldEqual, 0)
 				Wish(t, stderr, ShouldEqual, Dedent(`
 					module loaded
@@ -81,6 +87,7 @@ func TestEmergeInModule(t *testing.T) {
 			t.Run("the non-candidate release is still visible", func(t *testing.T) {
 				os.Chdir("../proj-baz")
 				exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge")
+				stderr, _ = SplitStepLogs(stderr)
 				Wish(t, exitCode, ShouldEqual, 0)
 				Wish(t, stderr, ShouldEqual, Dedent(`
 					module loaded
@@ -107,6 +114,7 @@ func TestEmergeViaModuleArg(t *testing.T) {
 	}
 	WithCwdClonedTmpDir(GetCwdAbs(), func() {
 		exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "example.org/proj-foo")
+		stderr, _ = SplitStepLogs(stderr)
 		Wish(t, exitCode, ShouldEqual, 0)
 		Wish(t, stderr, ShouldEqual, Dedent(`
 			module loaded
@@ -131,6 +139,7 @@ func TestEmergeViaModuleArg(t *testing.T) {
 
 			t.Run("another module can consume the candidate", func(t *testing.T) {
 				exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "example.org/proj-bar")
+				stderr, _ = SplitStepLogs(stderr)
 				Wish(t, exitCode, ShouldEqual, 0)
 				Wish(t, stderr, ShouldEqual, Dedent(`
 					module loaded
@@ -150,6 +159,7 @@ func TestEmergeViaModuleArg(t *testing.T) {
 
 			t.Run("the non-candidate release is still visible", func(t *testing.T) {
 				exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "example.org/proj-baz")
+				stderr, _ = SplitStepLogs(stderr)
 				Wish(t, exitCode, ShouldEqual, 0)
 				Wish(t, stderr, ShouldEqual, Dedent(`
 					module loaded
@@ -183,6 +193,7 @@ func TestEmergeRecursion(t *testing.T) {
 	t.Run("one recursion should succeed", func(t *testing.T) {
 		WithCwdClonedTmpDir(GetCwdAbs(), func() {
 			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "-r", "example.org/proj-bar")
+			stderr, _ = SplitStepLogs(stderr)
 			Wish(t, exitCode, ShouldEqual, 0)
 			// What comes next are assertions that match what the output currently is;
 			//  but it's not at all a statement that this is a polished experience, or what this output *should* be.
@@ -218,6 +229,169 @@ func TestEmergeRecursion(t *testing.T) {
 	//  (but possibly start another example dir?  this one is complex enough.)
 }
 
+func TestEmergeMultipleArgs(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	t.Run("names and paths can be mixed, and are evaluated in commission order", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "./example.org/proj-bar", "example.org/proj-foo")
+			stderr, _ = SplitStepLogs(stderr)
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stderr, ShouldEqual, Dedent(`
+				module loaded
+				module contains 1 steps
+				module evaluation plan order:
+				  - 01: main
+				imports pinned to hashes:
+				  - "base": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6
+				module eval complete.
+				module exports:
+				module loaded
+				module contains 1 steps
+				module evaluation plan order:
+				  - 01: main
+				imports pinned to hashes:
+				  - "base": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6
+				  - "pipe": tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv
+				module eval complete.
+				module exports:
+			`))
+			Wish(t, stdout, ShouldEqual, Dedent(`
+				{
+					"wowslot": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv"
+				}
+				{}
+			`))
+		})
+	})
+	t.Run("recursion from within a module needs no args", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			os.Chdir("example.org/proj-bar")
+			exitCode, _, _ := RunIntoBuffer("reach", "emerge", "-r")
+			Wish(t, exitCode, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestEmergePattern(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	t.Run("all modules beneath a dir should be evaluated in commission order", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "./example.org/...")
+			stderr, _ = SplitStepLogs(stderr)
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stderr, ShouldEqual, Dedent(`
+				module loaded
+				module contains 1 steps
+				module evaluation plan order:
+				  - 01: main
+				imports pinned to hashes:
+				  - "base": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6
+				module eval complete.
+				module exports:
+				module loaded
+				module contains 1 steps
+				module evaluation plan order:
+				  - 01: main
+				imports pinned to hashes:
+				  - "base": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6
+				  - "pipe": tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv
+				module eval complete.
+				module exports:
+				module loaded
+				module contains 1 steps
+				module evaluation plan order:
+				  - 01: main
+				imports pinned to hashes:
+				  - "base": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6
+				  - "pipe": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6
+				module eval complete.
+				module exports:
+			`))
+			Wish(t, stdout, ShouldEqual, Dedent(`
+				{
+					"wowslot": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv"
+				}
+				{}
+				{}
+			`))
+		})
+	})
+	t.Run("a module which fails to load should fail with its name, not crash", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			Wish(t, ioutil.WriteFile("example.org/proj-baz/module.tl", []byte(`{"imports":`), 0644), ShouldEqual, nil)
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "./example.org/...")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, stdout, ShouldEqual, "")
+			Wish(t, strings.HasPrefix(stderr, `reach: cannot determine commission order of modules: error loading module "example.org/proj-baz": `), ShouldEqual, true)
+			Wish(t, strings.Contains(stderr, "panic"), ShouldEqual, false)
+		})
+	})
+	t.Run("a pattern matching no modules should fail", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "./.timeless/...")
+			stderr, _ = SplitStepLogs(stderr)
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, stdout, ShouldEqual, "")
+			Wish(t, stderr, ShouldEqual, Dedent(`
+				reach: no modules found matching "./.timeless/..."
+			`))
+		})
+	})
+}
+
+func TestRunReport(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		exitCode, _, _ := RunIntoBuffer("reach", "emerge", "example.org/proj-foo")
+		Wish(t, exitCode, ShouldEqual, 0)
+
+		reports, err := filepath.Glob(".timeless/reports/*_example.org_proj-foo.json")
+		Wish(t, err, ShouldEqual, nil)
+		Wish(t, len(reports), ShouldEqual, 1)
+		content, err := ioutil.ReadFile(reports[0])
+		Wish(t, err, ShouldEqual, nil)
+		var report struct {
+			Module string
+			Pins   map[string]string
+			Steps  map[string]struct {
+				SetupHash string
+				Record    struct {
+					Results map[string]string
+				}
+			}
+			Exports map[string]string
+		}
+		Wish(t, json.Unmarshal(content, &report), ShouldEqual, nil)
+		Wish(t, report.Module, ShouldEqual, "example.org/proj-foo")
+		Wish(t, report.Pins, ShouldEqual, map[string]string{
+			"base": "tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6",
+		})
+		Wish(t, len(report.Steps), ShouldEqual, 1)
+		Wish(t, report.Steps["main"].SetupHash != "", ShouldEqual, true)
+		Wish(t, report.Steps["main"].Record.Results, ShouldEqual, map[string]string{
+			"out": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv",
+		})
+		Wish(t, report.Exports, ShouldEqual, map[string]string{
+			"wowslot": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv",
+		})
+	})
+	t.Run("failing to save the report should only warn", func(t *testing.T) {
+		WithCwdClonedTmpDir(GetCwdAbs(), func() {
+			Wish(t, ioutil.WriteFile(".timeless/reports", nil, 0644), ShouldEqual, nil)
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "example.org/proj-foo")
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, strings.Contains(stdout, `"wowslot": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv"`), ShouldEqual, true)
+			Wish(t, strings.Contains(stderr, "warning: cannot save run report: "), ShouldEqual, true)
+		})
+	})
+}
+
 func TestLint(t *testing.T) {
 	exitCode, stdout, stderr := RunIntoBuffer("reach", "catalog", "lint", ".timeless/catalog")
 	Wish(t, exitCode, ShouldEqual, 0)
@@ -226,3 +400,74 @@ func TestLint(t *testing.T) {
 		0 total warnings
 	`))
 }
+
+func TestSagaLifecycle(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		exitCode, _, _ := RunIntoBuffer("reach", "emerge", "--saga=frob", "example.org/proj-foo")
+		Wish(t, exitCode, ShouldEqual, 0)
+
+		t.Run("the saga should be listed", func(t *testing.T) {
+			exitCode, stdout, _ := RunIntoBuffer("reach", "saga", "list")
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stdout, ShouldEqual, "frob\n")
+		})
+		t.Run("the saga should show its candidates", func(t *testing.T) {
+			exitCode, stdout, _ := RunIntoBuffer("reach", "saga", "show", "frob")
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stdout, ShouldEqual, Dedent(`
+				{
+					"example.org/proj-foo": {
+						"wowslot": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv"
+					}
+				}
+			`))
+		})
+		t.Run("committing the saga should make a release", func(t *testing.T) {
+			exitCode, _, stderr := RunIntoBuffer("reach", "saga", "commit", "frob", "v0.02")
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stderr, ShouldEqual, "committed example.org/proj-foo:v0.02\n")
+			exitCode, stdout, _ := RunIntoBuffer("reach", "wares", "select", "releases", "example.org/proj-foo", "v0.02", "wowslot")
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stdout, ShouldEqual, "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv\n")
+
+			t.Run("the release should be reproducible from its replay", func(t *testing.T) {
+				exitCode, stdout, _ := RunIntoBuffer("reach", "catalog", "verify-replay", "example.org/proj-foo", "v0.02")
+				Wish(t, exitCode, ShouldEqual, 0)
+				Wish(t, stdout, ShouldEqual, Dedent(`
+					{
+						"module": "example.org/proj-foo",
+						"release": "v0.02",
+						"items": {
+							"wowslot": {
+								"expected": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv",
+								"actual": "tar:89LoLzgAYkndYpNQC7H94eR6tU6F4EWy2yFGouDCQz1cx9JpYmEPyDm2YWwYTGDvPv",
+								"match": true
+							}
+						},
+						"reproduced": true
+					}
+				`))
+			})
+			t.Run("the replay should pack outputs as the release did, whatever the workspace config says now", func(t *testing.T) {
+				Wish(t, ioutil.WriteFile(".timeless/workspace.tl", []byte(`{"output": {"mtime": "@0"}}`), 0644), ShouldEqual, nil)
+				defer os.Remove(".timeless/workspace.tl")
+				exitCode, _, _ := RunIntoBuffer("reach", "catalog", "verify-replay", "example.org/proj-foo", "v0.02")
+				Wish(t, exitCode, ShouldEqual, 0)
+			})
+			t.Run("committing again with the same name should be rejected", func(t *testing.T) {
+				exitCode, _, _ := RunIntoBuffer("reach", "saga", "commit", "frob", "v0.02")
+				Wish(t, exitCode, ShouldEqual, 1)
+			})
+		})
+		t.Run("discarding the saga should remove it", func(t *testing.T) {
+			exitCode, _, _ := RunIntoBuffer("reach", "saga", "discard", "frob")
+			Wish(t, exitCode, ShouldEqual, 0)
+			exitCode, stdout, _ := RunIntoBuffer("reach", "saga", "list")
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, stdout, ShouldEqual, "")
+		})
+	})
+}
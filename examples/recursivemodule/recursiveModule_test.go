@@ -1,6 +1,7 @@
 package hellomodule
 
 import (
+	"strings"
 	"testing"
 
 	. "github.com/warpfork/go-wish"
@@ -14,6 +15,7 @@ func Test(t *testing.T) {
 	}
 	WithCwdClonedTmpDir(GetCwdAbs(), func() {
 		exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge")
+		stderr, _ = SplitStepLogs(stderr)
 		Wish(t, exitCode, ShouldEqual, 0)
 		Wish(t, stderr, ShouldEqual, Dedent(`
 			module loaded
@@ -37,3 +39,47 @@ func Test(t *testing.T) {
 		`))
 	})
 }
+
+func TestParallel(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		exitCode, stdout, _ := RunIntoBuffer("reach", "emerge", "-j", "4")
+		Wish(t, exitCode, ShouldEqual, 0)
+		Wish(t, stdout, ShouldEqual, Dedent(`
+			{
+				"product": "tar:77k8uXWaArTqvyecQmjW9Xb3q3yMM9Mih842dA3HrrDW4Xs8uvU9kfipMWJKLQ5quZ"
+			}
+		`))
+	})
+}
+
+func TestPinOverride(t *testing.T) {
+	if testing.Short() {
+		t.Skipf("integration test -- not running with 'short' mode")
+	}
+	WithCwdClonedTmpDir(GetCwdAbs(), func() {
+		t.Run("overriding a submodule's import should show in the pins", func(t *testing.T) {
+			// Override with the same ware the catalog has, so the result is known.
+			exitCode, stdout, stderr := RunIntoBuffer("reach", "emerge", "--pin", "submodule-jamboree.image=catalog:froob.org/base:v1:linux-amd64")
+			stderr, _ = SplitStepLogs(stderr)
+			Wish(t, exitCode, ShouldEqual, 0)
+			Wish(t, strings.Contains(stderr, Dedent(`
+				imports pinned to hashes:
+				  - "base": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6
+				  - "submodule-jamboree.image": tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6 (overridden; was tar:6q7G4hWr283FpTa5Lf8heVqw9t97b5VoMU6AGszuBYAz9EzQdeHVFAou7c4W9vFcQ6)
+			`)), ShouldEqual, true)
+			Wish(t, stdout, ShouldEqual, Dedent(`
+				{
+					"product": "tar:77k8uXWaArTqvyecQmjW9Xb3q3yMM9Mih842dA3HrrDW4Xs8uvU9kfipMWJKLQ5quZ"
+				}
+			`))
+		})
+		t.Run("overriding a slot that isn't pinned should fail", func(t *testing.T) {
+			exitCode, _, stderr := RunIntoBuffer("reach", "emerge", "--pin", "submodule-jamboree.thingy=tar:aaaa")
+			Wish(t, exitCode, ShouldEqual, 1)
+			Wish(t, strings.Contains(stderr, `cannot override pin for "submodule-jamboree.thingy"`), ShouldEqual, true)
+		})
+	})
+}
@@ -3,6 +3,7 @@ package testutil
 import (
 	"bytes"
 	"context"
+	"strings"
 
 	"go.polydawn.net/reach/cmd/reach/app"
 )
@@ -13,3 +14,18 @@ func RunIntoBuffer(args ...string) (int, string, string) {
 	exitCode := reach.Main(context.Background(), args, nil, stdout, stderr)
 	return exitCode, stdout.String(), stderr.String()
 }
+
+// SplitStepLogs separates the logs of operation steps from the rest of
+// the stderr of an emerge, so tests can check the overall progress
+// messages exactly without depending on everything repeatr printed.
+func SplitStepLogs(stderr string) (other, stepLogs string) {
+	var otherBuf, stepBuf strings.Builder
+	for _, line := range strings.SplitAfter(stderr, "\n") {
+		if strings.HasPrefix(line, "beginning evaluation of step ") || strings.HasPrefix(line, "  \033[1;33m") {
+			stepBuf.WriteString(line)
+		} else {
+			otherBuf.WriteString(line)
+		}
+	}
+	return otherBuf.String(), stepBuf.String()
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/polydawn/refmt/obj/atlas"
 	"go.polydawn.net/go-timeless-api"
 	"go.polydawn.net/go-timeless-api/hitch"
+	"go.polydawn.net/reach/gadgets/operation"
 )
 
 type Tree struct {
@@ -18,10 +19,45 @@ type Tree struct {
 }
 
 const (
-	LineageFileName = "lineage.tl"
-	MirrorsFileName = "mirrors.tl"
+	LineageFileName   = "lineage.tl"
+	MirrorsFileName   = "mirrors.tl"
+	ReplayFilePrefix  = "replay-"
+	OutputsFilePrefix = "outputs-"
 )
 
+// ReplayFileName returns the name of the file holding the replay instructions
+// (that is, a module) for a release.
+func ReplayFileName(relName api.ReleaseName) string {
+	return ReplayFilePrefix + string(relName) + ".tl"
+}
+
+// OutputsFileName returns the name of the file holding the ReplayOutputs
+// for a release.
+func OutputsFileName(relName api.ReleaseName) string {
+	return OutputsFilePrefix + string(relName) + ".tl"
+}
+
+// ReplayOutputs records how every output of every operation was packed
+// when a release was built, so its replay can pack them the same way
+// (the workspace config saying how to pack outputs may since have changed).
+// Keys are of the form "step.slot", the step ref being fully contextualized,
+// as in the workspace config.
+type ReplayOutputs map[string]api.FormulaOutputSpec
+
+var atlas_ReplayOutputs = atlas.MustBuild(
+	api.FormulaOutputSpec_AtlasEntry,
+	api.FilesetFilters_AtlasEntry,
+)
+
+// OutputSpec returns how an output of an operation in the replay was packed.
+// Outputs which weren't recorded were packed the default way.
+func (ro ReplayOutputs) OutputSpec(step api.SubmoduleStepRef, slot api.SlotName) api.FormulaOutputSpec {
+	if spec, ok := ro[step.String()+"."+string(slot)]; ok {
+		return spec
+	}
+	return operation.DefaultOutputSpec
+}
+
 // LoadModuleLineage attempts to load the lineage file for a module from the catalog.
 // The result can never be nil unless there is an error since the lineage file
 // is the one file that's required for the rest of the catalog folder to be recognizable.
@@ -65,6 +101,71 @@ func (tree Tree) SaveModuleMirrors(modName api.ModuleName, ws api.WareSourcing)
 	return tree.saveModuleFile(modName, ws, api.Atlas_WareSourcing, "mirrors list", MirrorsFileName)
 }
 
+// LoadModuleReplay attempts to load the replay instructions for a release of a module.
+// The result is nil and nil error iff the file does not exist.
+func (tree Tree) LoadModuleReplay(modName api.ModuleName, relName api.ReleaseName) (mod *api.Module, err error) {
+	err = tree.loadModuleFile(modName, &mod, api.Atlas_Module, false, "replay", ReplayFileName(relName))
+	return
+}
+
+// SaveModuleReplay writes out the replay instructions for a release of a module.
+// The lineage must be written first (e.g. the dir must exist).
+func (tree Tree) SaveModuleReplay(modName api.ModuleName, relName api.ReleaseName, mod api.Module) error {
+	return tree.saveModuleFile(modName, mod, api.Atlas_Module, "replay", ReplayFileName(relName))
+}
+
+// LoadModuleOutputs attempts to load how the outputs were packed for a release of a module.
+// The result is nil and nil error iff the file does not exist.
+func (tree Tree) LoadModuleOutputs(modName api.ModuleName, relName api.ReleaseName) (outputs ReplayOutputs, err error) {
+	err = tree.loadModuleFile(modName, &outputs, atlas_ReplayOutputs, false, "replay outputs", OutputsFileName(relName))
+	return
+}
+
+// SaveModuleOutputs writes out how the outputs were packed for a release of a module.
+// The lineage must be written first (e.g. the dir must exist).
+func (tree Tree) SaveModuleOutputs(modName api.ModuleName, relName api.ReleaseName, outputs ReplayOutputs) error {
+	return tree.saveModuleFile(modName, outputs, atlas_ReplayOutputs, "replay outputs", OutputsFileName(relName))
+}
+
+// ListModules returns the names of every module in the tree which has
+// a lineage file, in sorted order.
+// If the tree's root dir does not exist, the result is empty (and not an error).
+func (tree Tree) ListModules() ([]api.ModuleName, error) {
+	modNames := []api.ModuleName{}
+	err := filepath.Walk(tree.Root, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			if pth == tree.Root && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if pth == tree.Root {
+			return nil
+		}
+		// Ignore dotfiles at any depth.  (.git is not unlikely here)
+		if info.Name()[0] == '.' {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || info.Name() != LineageFileName {
+			return nil
+		}
+		modNames = append(modNames, api.ModuleName(filepath.ToSlash(filepath.Dir(pth[len(tree.Root)+1:]))))
+		return nil
+	})
+	if err != nil {
+		return nil, errcat.ErrorDetailed(
+			hitch.ErrCorruptState,
+			fmt.Sprintf("cannot list modules in catalog %q: %s", tree.Root, err),
+			map[string]string{
+				"path": tree.Root,
+			})
+	}
+	return modNames, nil
+}
+
 //
 // above: Load and Save methods for known files.
 // --------
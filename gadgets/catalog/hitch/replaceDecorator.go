@@ -0,0 +1,290 @@
+package hitch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/polydawn/go-errcat"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/hitch"
+)
+
+// Replacement redirects catalog imports of one release (or one item of it)
+// somewhere else: either to another release (the same item names are used),
+// or, if only one item is replaced, to a specific ware.
+//
+// Replacing with "catalog:$module:candidate" is how a workspace develops
+// several modules together: importers of the module get its candidate
+// instead of the release they name, without editing any module.tl.
+type Replacement struct {
+	From api.ImportRef_Catalog  // ItemName is empty if the whole release is replaced.
+	To   *api.ImportRef_Catalog // ItemName is always empty.  Nil iff ToWare is set.
+
+	ToWare *api.WareID // Only allowed if From names an item.
+}
+
+// ParseReplacement parses a replacement, as found in workspace config.
+//
+// The key is "catalog:$module:$release" or "catalog:$module:$release:$item";
+// the value is "catalog:$module:$release", or a WareID if the key names an item.
+func ParseReplacement(from, to string) (*Replacement, error) {
+	fromRef, err := parseCatalogRef(from, true)
+	if err != nil {
+		return nil, usageError(from, "%q is not a valid import to replace: %s", from, err)
+	}
+	r := &Replacement{From: *fromRef}
+	if strings.HasPrefix(to, "catalog:") {
+		toRef, err := parseCatalogRef(to, false)
+		if err != nil {
+			return nil, usageError(from, "cannot replace %q with %q: %s", from, to, err)
+		}
+		r.To = toRef
+		return r, nil
+	}
+	if fromRef.ItemName == "" {
+		return nil, usageError(from, "cannot replace %q with %q: a whole release can only be replaced with another release", from, to)
+	}
+	wareID, err := api.ParseWareID(to)
+	if err != nil {
+		return nil, usageError(from, "cannot replace %q with %q: should be a release (\"catalog:$module:$release\") or a WareID: %s", from, to, err)
+	}
+	r.ToWare = &wareID
+	return r, nil
+}
+
+// parseCatalogRef parses "catalog:$module:$release", and also (if allowItem)
+// "catalog:$module:$release:$item".
+func parseCatalogRef(s string, allowItem bool) (*api.ImportRef_Catalog, error) {
+	ss := strings.Split(s, ":")
+	if ss[0] != "catalog" || len(ss) < 3 || len(ss) > 4 || (len(ss) == 4 && !allowItem) {
+		if allowItem {
+			return nil, fmt.Errorf("should be of the form \"catalog:$module:$release\" or \"catalog:$module:$release:$item\"")
+		}
+		return nil, fmt.Errorf("should be of the form \"catalog:$module:$release\"")
+	}
+	ref := api.ImportRef_Catalog{ModuleName: api.ModuleName(ss[1]), ReleaseName: api.ReleaseName(ss[2])}
+	if err := ref.ModuleName.Validate(); err != nil {
+		return nil, err
+	}
+	if len(ss) == 4 {
+		ref.ItemName = api.ItemName(ss[3])
+	}
+	return &ref, nil
+}
+
+func usageError(ref string, format string, args ...interface{}) error {
+	return errcat.ErrorDetailed(
+		hitch.ErrUsage,
+		fmt.Sprintf(format, args...),
+		map[string]string{
+			"ref": ref,
+		})
+}
+
+// ReplaceImport returns what a catalog import should be treated as, given
+// the replacements: another catalog import, a literal ingest (for
+// replacements with a ware), or the import unchanged.
+// Replacements of a single item take precedence over replacing its release.
+func ReplaceImport(ref api.ImportRef_Catalog, replacements []Replacement) api.ImportRef {
+	var match *Replacement
+	for i, r := range replacements {
+		if r.From.ModuleName != ref.ModuleName || r.From.ReleaseName != ref.ReleaseName {
+			continue
+		}
+		switch r.From.ItemName {
+		case ref.ItemName:
+			match = &replacements[i]
+		case "":
+			if match == nil {
+				match = &replacements[i]
+			}
+		}
+	}
+	switch {
+	case match == nil:
+		return ref
+	case match.ToWare != nil:
+		return api.ImportRef_Ingest{"literal", match.ToWare.String()}
+	default:
+		return api.ImportRef_Catalog{match.To.ModuleName, match.To.ReleaseName, ref.ItemName}
+	}
+}
+
+// ReplaceImports returns a copy of the module (and its submodules) with every
+// catalog import rewritten by ReplaceImport.  The original is not modified.
+//
+// This is the module as it was effectively evaluated, which is what
+// candidate pins and replays need to record.
+func ReplaceImports(mod api.Module, replacements []Replacement) api.Module {
+	if len(replacements) == 0 {
+		return mod
+	}
+	rewritten := mod
+	rewritten.Imports = make(map[api.SlotName]api.ImportRef, len(mod.Imports))
+	for slotName, importRef := range mod.Imports {
+		if catRef, ok := importRef.(api.ImportRef_Catalog); ok {
+			importRef = ReplaceImport(catRef, replacements)
+		}
+		rewritten.Imports[slotName] = importRef
+	}
+	rewritten.Steps = make(map[api.StepName]api.StepUnion, len(mod.Steps))
+	for stepName, step := range mod.Steps {
+		if submod, ok := step.(api.Module); ok {
+			step = ReplaceImports(submod, replacements)
+		}
+		rewritten.Steps[stepName] = step
+	}
+	return rewritten
+}
+
+// WithReplacements decorates the view tools so that the lineages they return
+// have replaced releases (and items) swapped out for their replacements.
+//
+// Replacement targets are looked up with the undecorated tools, so
+// replacements don't chain.  The releases a replacement targets must exist,
+// but the releases (or even lineages) being replaced needn't.
+func WithReplacements(
+	viewLineageTool hitch.ViewLineageTool,
+	viewWarehousesTool hitch.ViewWarehousesTool,
+	replacements []Replacement,
+) (
+	hitch.ViewLineageTool,
+	hitch.ViewWarehousesTool,
+) {
+	if len(replacements) == 0 {
+		return viewLineageTool, viewWarehousesTool
+	}
+	cat := replaceDecorator{viewLineageTool, viewWarehousesTool, replacements}
+	return cat.ViewLineage, cat.ViewWarehouses
+}
+
+type replaceDecorator struct {
+	ViewLineageDelegate    hitch.ViewLineageTool
+	ViewWarehousesDelegate hitch.ViewWarehousesTool
+	Replacements           []Replacement
+}
+
+// replacementsFor returns the replacements affecting a module's lineage:
+// whole releases first, then single items, so the latter win.
+func (cat replaceDecorator) replacementsFor(modName api.ModuleName) []Replacement {
+	var releases, items []Replacement
+	for _, r := range cat.Replacements {
+		switch {
+		case r.From.ModuleName != modName:
+		case r.From.ItemName == "":
+			releases = append(releases, r)
+		default:
+			items = append(items, r)
+		}
+	}
+	return append(releases, items...)
+}
+
+func (cat replaceDecorator) ViewLineage(
+	ctx context.Context,
+	modName api.ModuleName,
+) (*api.Lineage, error) {
+	replacements := cat.replacementsFor(modName)
+	lin, err := cat.ViewLineageDelegate(ctx, modName)
+	switch errcat.Category(err) {
+	case nil:
+		// continue!
+	case hitch.ErrNoSuchLineage:
+		if len(replacements) == 0 {
+			return nil, err
+		}
+		lin = &api.Lineage{Name: modName}
+	default:
+		return nil, err
+	}
+	if len(replacements) == 0 {
+		return lin, nil
+	}
+	// Copy the list of releases, so we're not modifying anything the delegate may hold onto.
+	result := *lin
+	result.Releases = append([]api.Release(nil), lin.Releases...)
+	for _, r := range replacements {
+		// Find (or make) the release to change, and copy its items.
+		idx := -1
+		for i, rel := range result.Releases {
+			if rel.Name == r.From.ReleaseName {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			result.Releases = append([]api.Release{{Name: r.From.ReleaseName}}, result.Releases...)
+			idx = 0
+		}
+		rel := result.Releases[idx]
+		items := make(map[api.ItemName]api.WareID, len(rel.Items))
+		for k, v := range rel.Items {
+			items[k] = v
+		}
+		// Fill in the replacement.
+		switch {
+		case r.ToWare != nil:
+			items[r.From.ItemName] = *r.ToWare
+		default:
+			target, err := cat.viewRelease(ctx, *r.To)
+			if err != nil {
+				return nil, err
+			}
+			if r.From.ItemName == "" {
+				items = target.Items
+				break
+			}
+			wareID, ok := target.Items[r.From.ItemName]
+			if !ok {
+				return nil, usageError(string(modName), "cannot replace %s:%s:%s with %s:%s: it has no item %q",
+					modName, r.From.ReleaseName, r.From.ItemName, r.To.ModuleName, r.To.ReleaseName, r.From.ItemName)
+			}
+			items[r.From.ItemName] = wareID
+		}
+		rel.Items = items
+		result.Releases[idx] = rel
+	}
+	return &result, nil
+}
+
+func (cat replaceDecorator) viewRelease(ctx context.Context, ref api.ImportRef_Catalog) (*api.Release, error) {
+	lin, err := cat.ViewLineageDelegate(ctx, ref.ModuleName)
+	if err != nil {
+		return nil, err
+	}
+	return hitch.LineagePluckReleaseByName(*lin, ref.ReleaseName)
+}
+
+// ViewWarehouses adds the mirrors of every module a replacement points to,
+// since wares from there may now be pinned as if they were this module's.
+func (cat replaceDecorator) ViewWarehouses(
+	ctx context.Context,
+	modName api.ModuleName,
+) (*api.WareSourcing, error) {
+	ws, err := cat.ViewWarehousesDelegate(ctx, modName)
+	switch errcat.Category(err) {
+	case nil:
+		// continue!
+	case hitch.ErrNoSuchLineage:
+		ws = &api.WareSourcing{}
+	default:
+		return nil, err
+	}
+	for _, r := range cat.replacementsFor(modName) {
+		if r.To == nil {
+			continue
+		}
+		more, err := cat.ViewWarehousesDelegate(ctx, r.To.ModuleName)
+		switch errcat.Category(err) {
+		case nil:
+			ws.Append(*more)
+		case hitch.ErrNoSuchLineage:
+			// Fine: candidates don't have mirrors.
+		default:
+			return nil, err
+		}
+	}
+	return ws, nil
+}
@@ -0,0 +1,77 @@
+package hitch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/polydawn/go-errcat"
+	. "github.com/warpfork/go-wish"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/hitch"
+)
+
+func TestReplacements(t *testing.T) {
+	wareA := api.WareID{"tar", "aaaa"}
+	wareB := api.WareID{"tar", "bbbb"}
+	wareC := api.WareID{"tar", "cccc"}
+	lineages := map[api.ModuleName]api.Lineage{
+		"lib": {Name: "lib", Releases: []api.Release{
+			{Name: "v1", Items: map[api.ItemName]api.WareID{"bin": wareA, "doc": wareA}},
+		}},
+		"lib-fork": {Name: "lib-fork", Releases: []api.Release{
+			{Name: "candidate", Items: map[api.ItemName]api.WareID{"bin": wareB, "doc": wareB}},
+		}},
+	}
+	viewLineage := func(ctx context.Context, modName api.ModuleName) (*api.Lineage, error) {
+		lin, ok := lineages[modName]
+		if !ok {
+			return nil, errcat.Errorf(hitch.ErrNoSuchLineage, "no lineage for %q", modName)
+		}
+		return &lin, nil
+	}
+	viewWarehouses := func(ctx context.Context, modName api.ModuleName) (*api.WareSourcing, error) {
+		return &api.WareSourcing{}, nil
+	}
+	replacements := func(kvs ...string) []Replacement {
+		rs := []Replacement{}
+		for i := 0; i < len(kvs); i += 2 {
+			r, err := ParseReplacement(kvs[i], kvs[i+1])
+			Wish(t, err, ShouldEqual, nil)
+			rs = append(rs, *r)
+		}
+		return rs
+	}
+	items := func(rs []Replacement, modName api.ModuleName, relName api.ReleaseName) map[api.ItemName]api.WareID {
+		view, _ := WithReplacements(viewLineage, viewWarehouses, rs)
+		lin, err := view(context.Background(), modName)
+		Wish(t, err, ShouldEqual, nil)
+		rel, err := hitch.LineagePluckReleaseByName(*lin, relName)
+		Wish(t, err, ShouldEqual, nil)
+		return rel.Items
+	}
+
+	t.Run("replacing a release with a candidate", func(t *testing.T) {
+		rs := replacements("catalog:lib:v1", "catalog:lib-fork:candidate")
+		Wish(t, items(rs, "lib", "v1"), ShouldEqual, map[api.ItemName]api.WareID{"bin": wareB, "doc": wareB})
+		Wish(t, ReplaceImport(api.ImportRef_Catalog{"lib", "v1", "bin"}, rs), ShouldEqual, api.ImportRef_Catalog{"lib-fork", "candidate", "bin"})
+		Wish(t, lineages["lib"].Releases[0].Items["bin"], ShouldEqual, wareA) // the delegate's data is untouched.
+	})
+	t.Run("replacing one item with a ware takes precedence over its release", func(t *testing.T) {
+		rs := replacements(
+			"catalog:lib:v1:doc", "tar:cccc",
+			"catalog:lib:v1", "catalog:lib-fork:candidate",
+		)
+		Wish(t, items(rs, "lib", "v1"), ShouldEqual, map[api.ItemName]api.WareID{"bin": wareB, "doc": wareC})
+		Wish(t, ReplaceImport(api.ImportRef_Catalog{"lib", "v1", "doc"}, rs), ShouldEqual, api.ImportRef_Ingest{"literal", "tar:cccc"})
+	})
+	t.Run("replacing a release that doesn't exist yet", func(t *testing.T) {
+		rs := replacements("catalog:lib:v2", "catalog:lib-fork:candidate")
+		Wish(t, items(rs, "lib", "v2"), ShouldEqual, map[api.ItemName]api.WareID{"bin": wareB, "doc": wareB})
+		Wish(t, items(rs, "lib", "v1"), ShouldEqual, map[api.ItemName]api.WareID{"bin": wareA, "doc": wareA})
+	})
+	t.Run("a whole release can't be replaced with a ware", func(t *testing.T) {
+		_, err := ParseReplacement("catalog:lib:v1", "tar:cccc")
+		Wish(t, errcat.Category(err), ShouldEqual, hitch.ErrUsage)
+	})
+}
@@ -1,14 +1,52 @@
 package catalog
 
+import (
+	"fmt"
+
+	"github.com/polydawn/go-errcat"
+	"go.polydawn.net/go-timeless-api/hitch"
+)
+
 type SagaName struct{ s string }
 
 var SagaNameZero = SagaName{}
 
+// ParseSagaName checks that a string is a valid saga name and returns it.
+//
+// Saga names are used as dir names in the candidates tree, so they're
+// restricted to lowercase letters, digits, and dashes; must be non-empty;
+// and may not start with a dash.
 func ParseSagaName(s string) (*SagaName, error) {
-	// TODO check boundedness to [a-z0-9-], etc
+	if err := validateSagaName(s); err != nil {
+		return nil, errcat.ErrorDetailed(
+			hitch.ErrUsage,
+			fmt.Sprintf("%q is not a valid saga name: %s", s, err),
+			map[string]string{
+				"saga": s,
+			})
+	}
 	return &SagaName{s}, nil
 }
 
+func validateSagaName(s string) error {
+	if len(s) == 0 {
+		return fmt.Errorf("cannot be empty")
+	}
+	if s[0] == '-' {
+		return fmt.Errorf("cannot start with '-'")
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9':
+		case r == '-':
+		default:
+			return fmt.Errorf("only [a-z0-9-] are allowed, found %q", r)
+		}
+	}
+	return nil
+}
+
 func (sn SagaName) String() string {
 	return sn.s
 }
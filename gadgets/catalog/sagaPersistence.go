@@ -1,18 +1,107 @@
 package catalog
 
 import (
+	"fmt"
 	"io"
+	"os"
 	"path/filepath"
+	"sort"
 
+	"github.com/polydawn/go-errcat"
+	"github.com/polydawn/refmt/obj/atlas"
 	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/go-timeless-api/hitch"
 	"go.polydawn.net/reach/gadgets/layout"
 )
 
-func SaveCandidateRelease(landmarks layout.Workspace, sagaName SagaName, modName api.ModuleName, content map[api.ItemName]api.WareID, stderr io.Writer) error {
-	tree := Tree{
-		filepath.Join(landmarks.WorkspaceRoot(), ".timeless/candidates/", sagaName.String()),
+// CandidateTree returns the catalog tree where candidate releases for
+// the given saga are stored.
+func CandidateTree(landmarks layout.Workspace, sagaName SagaName) Tree {
+	return Tree{
+		filepath.Join(landmarks.CandidatesRoot(), sagaName.String()),
 	}
-	return tree.SaveModuleLineage(modName, api.Lineage{
+}
+
+// ListSagas returns the names of all sagas in the workspace which have
+// a candidates dir, in sorted order.
+func ListSagas(landmarks layout.Workspace) ([]SagaName, error) {
+	f, err := os.Open(landmarks.CandidatesRoot())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errcat.ErrorDetailed(
+			hitch.ErrCorruptState,
+			fmt.Sprintf("cannot list sagas: %s", err),
+			map[string]string{})
+	}
+	defer f.Close()
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		return nil, errcat.ErrorDetailed(
+			hitch.ErrCorruptState,
+			fmt.Sprintf("cannot list sagas: %s", err),
+			map[string]string{})
+	}
+	sagaNames := []SagaName{}
+	for _, fi := range fis {
+		if !fi.IsDir() || validateSagaName(fi.Name()) != nil {
+			continue
+		}
+		sagaNames = append(sagaNames, SagaName{fi.Name()})
+	}
+	sort.Slice(sagaNames, func(i, j int) bool { return sagaNames[i].s < sagaNames[j].s })
+	return sagaNames, nil
+}
+
+// DiscardSaga removes all candidate releases recorded in a saga.
+// It's not an error if the saga doesn't exist.
+func DiscardSaga(landmarks layout.Workspace, sagaName SagaName) error {
+	if err := os.RemoveAll(CandidateTree(landmarks, sagaName).Root); err != nil {
+		return errcat.ErrorDetailed(
+			hitch.ErrCorruptState,
+			fmt.Sprintf("cannot discard saga %q: %s", sagaName, err),
+			map[string]string{
+				"saga": sagaName.String(),
+			})
+	}
+	return nil
+}
+
+// SaveCandidateRelease records the results of evaluating a module as the
+// "candidate" release for that module in the saga, along with the pins of
+// the module's catalog imports (see CandidatePins).
+//
+// Saving a candidate may make other candidates in the saga stale: if they
+// imported the previous candidate of this module, and the results have
+// changed, those dependents are evicted (see EvictStaleCandidates).
+func SaveCandidateRelease(
+	landmarks layout.Workspace,
+	sagaName SagaName,
+	modName api.ModuleName,
+	mod api.Module,
+	pins funcs.Pins,
+	content map[api.ItemName]api.WareID,
+	stderr io.Writer,
+) error {
+	tree := CandidateTree(landmarks, sagaName)
+
+	// Order of operations here is chosen so that being killed at any point
+	//  leaves the saga in a state that's either correct or can be repaired:
+	//   - remove any old lineage file first, so there's no candidate at all
+	//     while the pins are inconsistent with it;
+	//   - then the pins;
+	//   - then the lineage, which is what makes the candidate visible;
+	//   - then evict stale dependents, which is idempotent, and will be
+	//     redone by any later save (or commit) if we don't finish it here.
+	if err := tree.removeCandidate(modName); err != nil {
+		return err
+	}
+	if err := tree.saveModuleCandidatePins(modName, candidatePinsForModule("", mod, pins)); err != nil {
+		return err
+	}
+	if err := tree.SaveModuleLineage(modName, api.Lineage{
 		Name: modName,
 		Releases: []api.Release{
 			{
@@ -20,34 +109,273 @@ func SaveCandidateRelease(landmarks layout.Workspace, sagaName SagaName, modName
 				Items: content,
 			},
 		},
-	})
+	}); err != nil {
+		return err
+	}
+	return EvictStaleCandidates(landmarks, sagaName, stderr)
 }
 
-// Dependent builds will need to be *evicted* from the saga if a module
-// is rebuilt and comes up with a different set of result contents.
-// Not sure what a good UX is for that.  Only comes up in manual mode.
-// Possible that we should save import resolutions to make this possible
-// to recheck idempotently (we wouldn't want insanity to result from
-// killing the reach process during that eviction phase!).
+// CandidatePins records the catalog imports a candidate was built against,
+// and the WareIDs each resolved to at the time.
+// Keys are the import refs in string form (e.g. "catalog:mod:candidate:item").
+//
+// Imports of other candidates are what we really need this for: releases
+// are immutable once committed, but candidates can be replaced by rebuilds.
+type CandidatePins map[string]api.WareID
+
+const CandidatePinsFileName = "pins.tl"
+
+var atlas_CandidatePins = atlas.MustBuild(api.WareID_AtlasEntry)
 
-func SaveCandidateReplay(landmarks layout.Workspace, sagaName SagaName, modName api.ModuleName, mod api.Module, stderr io.Writer) error {
-	tree := Tree{
-		filepath.Join(landmarks.WorkspaceRoot(), ".timeless/candidates/", sagaName.String()),
+// candidatePinsForModule collects the pins for every catalog import
+// in the module, and its submodules.
+func candidatePinsForModule(ctxPth api.SubmoduleRef, mod api.Module, pins funcs.Pins) CandidatePins {
+	result := CandidatePins{}
+	for slotName, importRef := range mod.Imports {
+		catRef, ok := importRef.(api.ImportRef_Catalog)
+		if !ok {
+			continue
+		}
+		if wareID, ok := pins[api.SubmoduleSlotRef{ctxPth, api.SlotRef{"", slotName}}]; ok {
+			result[catRef.String()] = wareID
+		}
 	}
+	for stepName, step := range mod.Steps {
+		if submod, ok := step.(api.Module); ok {
+			for k, v := range candidatePinsForModule(ctxPth.Child(stepName), submod, pins) {
+				result[k] = v
+			}
+		}
+	}
+	return result
+}
 
-	// Rewrite ingests
-	//  Error if export missing
-	// TODO
+// loadModuleCandidatePins loads the pins recorded for a candidate.
+// The result is nil and nil error iff the file does not exist.
+func (tree Tree) loadModuleCandidatePins(modName api.ModuleName) (pins CandidatePins, err error) {
+	err = tree.loadModuleFile(modName, &pins, atlas_CandidatePins, false, "candidate pins", CandidatePinsFileName)
+	return
+}
 
-	// Write rewritten module to file
-	//  n.b. there's a good chance this still has "candidate" releases in it;
-	//   that's okay for now, but we'll rewrite them before committing the saga.
-	// TODO there's no func for this on Tree yet
-	_ = tree
+// saveModuleCandidatePins writes out the pins recorded for a candidate.
+// The dirs will be created if necessary.
+func (tree Tree) saveModuleCandidatePins(modName api.ModuleName, pins CandidatePins) error {
+	if err := os.MkdirAll(filepath.Join(tree.Root, string(modName)), 0755); err != nil {
+		return errcat.ErrorDetailed(
+			hitch.ErrCorruptState,
+			fmt.Sprintf("cannot save candidate pins for module %q: %s", modName, err),
+			map[string]string{
+				"ref": string(modName),
+			})
+	}
+	return tree.saveModuleFile(modName, pins, atlas_CandidatePins, "candidate pins", CandidatePinsFileName)
+}
 
+// removeCandidate removes a module's candidate from the tree.
+// The lineage file goes first: without it, the rest of the dir is not
+// a candidate (see ListModules), so it's fine if we're interrupted
+// before removing the rest.
+func (tree Tree) removeCandidate(modName api.ModuleName) error {
+	modPath := filepath.Join(tree.Root, string(modName))
+	if err := os.Remove(filepath.Join(modPath, LineageFileName)); err != nil && !os.IsNotExist(err) {
+		return errcat.ErrorDetailed(
+			hitch.ErrCorruptState,
+			fmt.Sprintf("cannot remove candidate for module %q: %s", modName, err),
+			map[string]string{
+				"ref": string(modName),
+			})
+	}
+	// Remove the other files, but not the dir itself: there may be other modules beneath it.
+	for _, filename := range []string{CandidatePinsFileName, ReplayFileName("candidate"), OutputsFileName("candidate")} {
+		if err := os.Remove(filepath.Join(modPath, filename)); err != nil && !os.IsNotExist(err) {
+			return errcat.ErrorDetailed(
+				hitch.ErrCorruptState,
+				fmt.Sprintf("cannot remove candidate for module %q: %s", modName, err),
+				map[string]string{
+					"ref": string(modName),
+				})
+		}
+	}
 	return nil
 }
 
+// EvictStaleCandidates removes every candidate in the saga which was built
+// against another candidate that has since changed (or been removed).
+//
+// Evicting a candidate can make its own dependents stale in turn, so this
+// repeats until nothing more is evicted.  Candidates with no recorded pins
+// are left alone.
+//
+// This is idempotent, so it's safe to call again after being interrupted.
+func EvictStaleCandidates(landmarks layout.Workspace, sagaName SagaName, stderr io.Writer) error {
+	tree := CandidateTree(landmarks, sagaName)
+	for {
+		modNames, err := tree.ListModules()
+		if err != nil {
+			return err
+		}
+		evicted := 0
+		for _, modName := range modNames {
+			reason, err := tree.candidateStaleness(modName)
+			if err != nil {
+				return err
+			}
+			if reason == "" {
+				continue
+			}
+			if err := tree.removeCandidate(modName); err != nil {
+				return err
+			}
+			fmt.Fprintf(stderr, "evicted candidate for module %q from saga %q: %s\n", modName, sagaName, reason)
+			evicted++
+		}
+		if evicted == 0 {
+			return nil
+		}
+	}
+}
+
+// candidateStaleness returns a description of why a candidate is stale,
+// or empty string if it isn't.
+func (tree Tree) candidateStaleness(modName api.ModuleName) (string, error) {
+	pins, err := tree.loadModuleCandidatePins(modName)
+	if err != nil {
+		return "", err
+	}
+	refStrs := make([]string, 0, len(pins))
+	for refStr := range pins {
+		refStrs = append(refStrs, refStr)
+	}
+	sort.Strings(refStrs)
+	for _, refStr := range refStrs {
+		importRef, err := api.ParseImportRef(refStr)
+		if err != nil {
+			return "", errcat.ErrorDetailed(
+				hitch.ErrCorruptState,
+				fmt.Sprintf("candidate pins for module %q are corrupt: %s", modName, err),
+				map[string]string{
+					"ref": string(modName),
+				})
+		}
+		catRef, ok := importRef.(api.ImportRef_Catalog)
+		if !ok || catRef.ReleaseName != "candidate" {
+			continue
+		}
+		lin, err := tree.LoadModuleLineage(catRef.ModuleName)
+		switch errcat.Category(err) {
+		case nil:
+			// continue!
+		case hitch.ErrNoSuchLineage:
+			return fmt.Sprintf("it was built against %s, which is no longer in the saga", refStr), nil
+		default:
+			return "", err
+		}
+		rel, err := hitch.LineagePluckReleaseByName(*lin, "candidate")
+		if err != nil {
+			return "", err
+		}
+		if current := rel.Items[catRef.ItemName]; current != pins[refStr] {
+			return fmt.Sprintf("it was built against %s = %s, which is now %s", refStr, pins[refStr], current), nil
+		}
+	}
+	return "", nil
+}
+
+// SaveCandidateReplay writes the replay instructions for a candidate release:
+// the module, rewritten so that every import which isn't already a stable
+// reference is replaced by the exact ware it resolved to.
+//
+// Specifically: ingests, and imports of "candidate" releases, are both
+// replaced by literal ingests of the WareID they were pinned to.
+// (Imports of other releases from the catalog are left as they are.)
+// This is done for submodules too, recursively.
+//
+// How the outputs were packed is saved alongside (see ReplayOutputs),
+// since that's part of what the replay needs to reproduce the release.
+//
+// The exports are checked against the module: every export the module
+// declares must be present, or it's an error (and nothing is saved).
+//
+// SaveCandidateRelease must be called first.
+func SaveCandidateReplay(
+	landmarks layout.Workspace,
+	sagaName SagaName,
+	modName api.ModuleName,
+	mod api.Module,
+	pins funcs.Pins,
+	outputs ReplayOutputs,
+	exports map[api.ItemName]api.WareID,
+	stderr io.Writer,
+) error {
+	tree := CandidateTree(landmarks, sagaName)
+
+	// Check exports.
+	for itemName := range mod.Exports {
+		if _, ok := exports[itemName]; !ok {
+			return errcat.ErrorDetailed(
+				hitch.ErrUsage,
+				fmt.Sprintf("cannot save replay for module %q: export %q is missing from results", modName, itemName),
+				map[string]string{
+					"ref": string(modName),
+				})
+		}
+	}
+
+	// Rewrite ingests and candidates to pins.
+	replay, err := rewriteReplay("", mod, pins)
+	if err != nil {
+		return errcat.ErrorDetailed(
+			hitch.ErrUsage,
+			fmt.Sprintf("cannot save replay for module %q: %s", modName, err),
+			map[string]string{
+				"ref": string(modName),
+			})
+	}
+
+	// Write rewritten module to file, and how its outputs were packed.
+	if err := tree.SaveModuleReplay(modName, "candidate", replay); err != nil {
+		return err
+	}
+	return tree.SaveModuleOutputs(modName, "candidate", outputs)
+}
+
+// rewriteReplay returns a copy of the module with all ingest and candidate
+// imports replaced by literal pins.  The original module is not modified.
+func rewriteReplay(ctxPth api.SubmoduleRef, mod api.Module, pins funcs.Pins) (api.Module, error) {
+	replay := mod
+	replay.Imports = make(map[api.SlotName]api.ImportRef, len(mod.Imports))
+	for slotName, importRef := range mod.Imports {
+		pinned := false
+		switch ref2 := importRef.(type) {
+		case api.ImportRef_Catalog:
+			pinned = ref2.ReleaseName == "candidate"
+		case api.ImportRef_Ingest:
+			pinned = true
+		}
+		if !pinned {
+			replay.Imports[slotName] = importRef
+			continue
+		}
+		wareID, ok := pins[api.SubmoduleSlotRef{ctxPth, api.SlotRef{"", slotName}}]
+		if !ok {
+			return replay, fmt.Errorf("missing pin for import %q in module %s", slotName, ctxPth)
+		}
+		replay.Imports[slotName] = api.ImportRef_Ingest{"literal", wareID.String()}
+	}
+	replay.Steps = make(map[api.StepName]api.StepUnion, len(mod.Steps))
+	for stepName, step := range mod.Steps {
+		if submod, ok := step.(api.Module); ok {
+			var err error
+			step, err = rewriteReplay(ctxPth.Child(stepName), submod, pins)
+			if err != nil {
+				return replay, err
+			}
+		}
+		replay.Steps[stepName] = step
+	}
+	return replay, nil
+}
+
 // Seems like it would be nice to generate a viable 'mirrors.tl' file as well.
 // But it's hard to spec out what that should behave like.
 // Often, the locally viable URLs are *not* publicly viable.
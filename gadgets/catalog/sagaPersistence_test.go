@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/warpfork/go-wish"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/reach/gadgets/layout"
+)
+
+func TestCandidateEviction(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "reach-test-saga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := os.Mkdir(filepath.Join(tmpdir, ".timeless"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lm, err := layout.FindWorkspace(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sagaName, _ := ParseSagaName("test")
+	tree := CandidateTree(*lm, *sagaName)
+
+	wareA := api.WareID{"tar", "aaaa"}
+	wareB := api.WareID{"tar", "bbbb"}
+	// "foo" has no imports; "bar" imports foo's candidate; "baz" imports bar's candidate.
+	modFoo := api.Module{}
+	modImporting := func(upstream api.ModuleName) api.Module {
+		return api.Module{Imports: map[api.SlotName]api.ImportRef{
+			"x": api.ImportRef_Catalog{upstream, "candidate", "out"},
+		}}
+	}
+	pinnedTo := func(wareID api.WareID) funcs.Pins {
+		return funcs.Pins{api.SubmoduleSlotRef{"", api.SlotRef{"", "x"}}: wareID}
+	}
+	save := func(modName api.ModuleName, mod api.Module, pins funcs.Pins, out api.WareID) string {
+		var stderr bytes.Buffer
+		err := SaveCandidateRelease(*lm, *sagaName, modName, mod, pins, map[api.ItemName]api.WareID{"out": out}, &stderr)
+		Wish(t, err, ShouldEqual, nil)
+		return stderr.String()
+	}
+	listed := func() []api.ModuleName {
+		modNames, err := tree.ListModules()
+		Wish(t, err, ShouldEqual, nil)
+		return modNames
+	}
+
+	save("foo", modFoo, nil, wareA)
+	save("bar", modImporting("foo"), pinnedTo(wareA), wareA)
+	save("baz", modImporting("bar"), pinnedTo(wareA), wareA)
+	Wish(t, listed(), ShouldEqual, []api.ModuleName{"bar", "baz", "foo"})
+
+	t.Run("rebuilding with the same results should evict nothing", func(t *testing.T) {
+		Wish(t, save("foo", modFoo, nil, wareA), ShouldEqual, "")
+		Wish(t, listed(), ShouldEqual, []api.ModuleName{"bar", "baz", "foo"})
+	})
+	t.Run("rebuilding with different results should evict dependents transitively", func(t *testing.T) {
+		Wish(t, save("foo", modFoo, nil, wareB), ShouldEqual, Dedent(`
+			evicted candidate for module "bar" from saga "test": it was built against catalog:foo:candidate:out = tar:aaaa, which is now tar:bbbb
+			evicted candidate for module "baz" from saga "test": it was built against catalog:bar:candidate:out, which is no longer in the saga
+		`))
+		Wish(t, listed(), ShouldEqual, []api.ModuleName{"foo"})
+	})
+}
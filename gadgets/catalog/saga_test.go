@@ -0,0 +1,19 @@
+package catalog
+
+import (
+	"testing"
+
+	. "github.com/warpfork/go-wish"
+)
+
+func TestParseSagaName(t *testing.T) {
+	for _, s := range []string{"default", "v2", "release-2018-06"} {
+		sn, err := ParseSagaName(s)
+		Wish(t, err, ShouldEqual, nil)
+		Wish(t, sn.String(), ShouldEqual, s)
+	}
+	for _, s := range []string{"", "-lead", "Upper", "with space", "dot.ted", "sl/ash", "../escape"} {
+		_, err := ParseSagaName(s)
+		Wish(t, err != nil, ShouldEqual, true)
+	}
+}
@@ -1,13 +1,14 @@
 package commission
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strings"
 
 	"go.polydawn.net/go-timeless-api"
 	"go.polydawn.net/go-timeless-api/hitch"
-	"go.polydawn.net/reach/gadgets/catalog"
+	hitchGadget "go.polydawn.net/reach/gadgets/catalog/hitch"
 	"go.polydawn.net/reach/gadgets/module"
 	"go.polydawn.net/reach/gadgets/workspace"
 )
@@ -23,6 +24,26 @@ import (
 	correct and complete evaluation of the whole set.
 */
 func CommissionOrder(ws workspace.Workspace, wantList ...api.ModuleName) ([]api.ModuleName, error) {
+	return commissionOrder(ws, wantList, nil)
+}
+
+/*
+	CommissionOrderAmong is like CommissionOrder, but only considers the
+	ModuleNames in the wantList: "candidate" imports of any other module are
+	assumed to already be satisfied, and are not recursed into.
+
+	This is useful for evaluating a group of modules together without
+	allowing recursion outside of the group.
+*/
+func CommissionOrderAmong(ws workspace.Workspace, wantList ...api.ModuleName) ([]api.ModuleName, error) {
+	within := make(map[api.ModuleName]struct{}, len(wantList))
+	for _, modName := range wantList {
+		within[modName] = struct{}{}
+	}
+	return commissionOrder(ws, wantList, within)
+}
+
+func commissionOrder(ws workspace.Workspace, wantList []api.ModuleName, within map[api.ModuleName]struct{}) ([]api.ModuleName, error) {
 	// Sort nodes by their name (this is our tiebreaker, in advance).
 	nodesOrdered := make([]api.ModuleName, len(wantList))
 	copy(nodesOrdered, wantList)
@@ -31,7 +52,7 @@ func CommissionOrder(ws workspace.Workspace, wantList ...api.ModuleName) ([]api.
 	visited := map[api.ModuleName]struct{}{}
 	result := make([]api.ModuleName, 0, len(wantList))
 	for _, node := range nodesOrdered {
-		if err := orderModules_visit(ws, node, visited, []string{}, &result); err != nil {
+		if err := orderModules_visit(ws, node, within, visited, []string{}, &result); err != nil {
 			return nil, err
 		}
 	}
@@ -41,6 +62,7 @@ func CommissionOrder(ws workspace.Workspace, wantList ...api.ModuleName) ([]api.
 func orderModules_visit(
 	ws workspace.Workspace,
 	node api.ModuleName,
+	within map[api.ModuleName]struct{}, // if non-nil, recursion is limited to these nodes.
 	visited map[api.ModuleName]struct{},
 	backtrace []string,
 	result *[]api.ModuleName,
@@ -62,9 +84,12 @@ func orderModules_visit(
 
 	// Load module via the workspace.
 	modLayout := ws.GetModuleLayout(node)
+	if modLayout == nil {
+		return fmt.Errorf("module %q is not mapped to any path by the workspace config", node)
+	}
 	mod, err := module.Load(*modLayout)
 	if err != nil {
-		return fmt.Errorf("error loading module: %s", err)
+		return fmt.Errorf("error loading module %q: %s", node, err)
 	}
 
 	// Collect all imports.
@@ -78,21 +103,32 @@ func orderModules_visit(
 	//  - For catalogs with "candidate" version: save to a new list: we'll recurse on these.
 	//  - For parent refs: ignore it, the correctness of those is module's internal problem.
 	//  - For ingests: ignore it, we assume it'll work itself out.
+	//  Catalog imports the workspace replaces are treated as what they're replaced with;
+	//   so in particular, replacing with a candidate is a recursion edge too.
 	candidateImports := []api.ModuleName(nil)
 	for _, imp := range imports {
+		if catRef, ok := imp.(api.ImportRef_Catalog); ok {
+			imp = hitchGadget.ReplaceImport(catRef, ws.Replacements())
+		}
 		switch imp2 := imp.(type) {
 		case api.ImportRef_Catalog:
 			switch imp2.ReleaseName {
 			case "candidate":
 				// Save these; this are the ones we care about to recurse.
+				//  (Unless we're limited to a set of modules, and this isn't in it.)
+				if within != nil {
+					if _, ok := within[imp2.ModuleName]; !ok {
+						continue
+					}
+				}
 				candidateImports = append(candidateImports, imp2.ModuleName)
 			default:
 				// Do a quick check that we'll be able to get this version.
 				//  This will also be done when eval'ing the module,
 				//   so strictly speaking we certainly don't *need* to here,
 				//   but it's cheap enough to check now as well as later.
-				catTree := catalog.Tree{ws.Layout.CatalogRoot()}
-				modCat, err := catTree.LoadModuleLineage(imp2.ModuleName)
+				viewLineageTool, _ := hitchGadget.ViewTools(ws.CatalogTrees()...)
+				modCat, err := viewLineageTool(context.TODO(), imp2.ModuleName)
 				if err != nil {
 					return fmt.Errorf("unable to resolve import %q (wanted by module %q): %s",
 						imp, node, err)
@@ -114,9 +150,8 @@ func orderModules_visit(
 	// Sort the dependency nodes by name, then recurse.
 	//  This sort is necessary for deterministic order of unrelated nodes.
 	sort.Sort(moduleNameByLex(candidateImports))
-	fmt.Printf("for module %q, discovered recursive links to: %v\n", node, candidateImports)
 	for _, imp := range candidateImports {
-		if err := orderModules_visit(ws, imp, visited, backtrace, result); err != nil {
+		if err := orderModules_visit(ws, imp, within, visited, backtrace, result); err != nil {
 			return err
 		}
 	}
@@ -3,6 +3,7 @@ package layout
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/warpfork/go-errcat"
@@ -83,18 +84,19 @@ func FindWorkspace(startPath string) (*Workspace, error) {
 	}
 }
 
-// IsModuleName whether a string is prefixed with ".":
+// IsModuleName whether a string is prefixed with "." or "/":
 // if not, IsModuleName returns true: str should be interpreted as a ModuleName,
 // and you can use `workspace.Workspace.GetModuleLayout` to find a layout
 // corresponding to it (or, just use it directly in catalog functions, etc);
-// if it is, IsModuleName returns false: str should be considered a *path*,
+// if it is, IsModuleName returns false: str should be considered a *path*
+// (relative or absolute, respectively),
 // and you should use FindModule or ExpectModule to get a layout.Module.
 //
 // As a corner case, empty string is considered false (but the caller may
 // wish to consider this case separately anyway -- typically, a lack of
 // any name or string at all can be taken to mean "use FindModule on cwd").
 func IsModuleName(str string) bool {
-	if len(str) < 1 || str[0] == '.' {
+	if len(str) < 1 || str[0] == '.' || str[0] == '/' {
 		return false
 	}
 	return true
@@ -203,5 +205,67 @@ func ExpectModule(lm Workspace, pth string) (*Module, error) {
 	return nil, errcat.Errorf(ModuleNotFound, "no module found")
 }
 
-// Future: `FindModulesBeneath(startPath) ([]Module)` -- we would use this to
-//  handle commands like `reach emerge ./group/...`.
+// FindModulesBeneath walks the filesystem from startPath downward and returns
+// every module found (including at startPath itself, if there's one there).
+//
+// This is used to handle commands like `reach emerge ./group/...`.
+//
+// The walk skips the '.timeless' dir, any other dirs with names starting
+// with ".", and any dirs which are the root of another (nested) workspace.
+// Symlinks are not followed: a 'module.tl' which is a symlink (or anything
+// else but a regular file) is skipped, as if it weren't there.
+//
+// The modules are returned in lexical order of their paths.
+// Paths are in the same format as the startPath (but clean'd), as with
+// FindModule.  Finding zero modules is not an error; an empty slice
+// is returned.
+//
+// If the startPath is not included under the workspace's root path, an error
+// will be raised immediately.
+func FindModulesBeneath(lm Workspace, startPath string) ([]Module, error) {
+	startClean := filepath.Clean(startPath)
+
+	if !strings.HasPrefix(startClean+"/", lm.workspaceRoot+"/") {
+		return nil, errcat.Errorf(ModuleSearchError, "module path must be contained within a workspace root (workspace root is %q)", lm.workspaceRoot)
+	}
+
+	modules := []Module{}
+	err := filepath.Walk(startClean, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// Skip dotdirs (this includes '.timeless').
+			//  The start path itself is exempt: it might be "." after all.
+			if pth != startClean && info.Name()[0] == '.' {
+				return filepath.SkipDir
+			}
+			// Skip nested workspaces.
+			if pth != lm.workspaceRoot {
+				if fi, err := os.Stat(filepath.Join(pth, ".timeless")); err == nil && fi.IsDir() {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if info.Name() != "module.tl" {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil // e.g. a symlink, which we don't follow.
+		}
+		modules = append(modules, Module{
+			lm,
+			filepath.Dir(pth),
+		})
+		return nil
+	})
+	if err != nil {
+		if errcat.Category(err) == ModuleSearchError {
+			return nil, err
+		}
+		return nil, errcat.Errorf(ModuleSearchError, "%s", err)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].moduleRoot < modules[j].moduleRoot })
+	return modules, nil
+}
@@ -0,0 +1,40 @@
+package layout
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/warpfork/go-wish"
+)
+
+func TestFindModulesBeneath(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "reach-test-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	for _, dir := range []string{".timeless", "a", "b", "c/.hidden"} {
+		if err := os.MkdirAll(filepath.Join(tmpdir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pth := range []string{"a/module.tl", "c/.hidden/module.tl"} {
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, pth), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("../a/module.tl", filepath.Join(tmpdir, "b", "module.tl")); err != nil {
+		t.Fatal(err)
+	}
+	lm, err := FindWorkspace(tmpdir)
+	Wish(t, err, ShouldEqual, nil)
+
+	t.Run("symlinked and hidden modules should be skipped", func(t *testing.T) {
+		modules, err := FindModulesBeneath(*lm, tmpdir)
+		Wish(t, err, ShouldEqual, nil)
+		Wish(t, len(modules), ShouldEqual, 1)
+		Wish(t, modules[0].ModuleRoot(), ShouldEqual, filepath.Join(tmpdir, "a"))
+	})
+}
@@ -32,6 +32,18 @@ func (lm Workspace) CatalogRoot() string {
 	// review: would we get better log messages if we resolved any symlinks first?
 	return filepath.Join(lm.workspaceRoot, ".timeless", "catalog")
 }
+func (lm Workspace) CandidatesRoot() string {
+	// review: would we get better log messages if we resolved any symlinks first?
+	return filepath.Join(lm.workspaceRoot, ".timeless", "candidates")
+}
+func (lm Workspace) ReportsRoot() string {
+	// review: would we get better log messages if we resolved any symlinks first?
+	return filepath.Join(lm.workspaceRoot, ".timeless", "reports")
+}
+func (lm Workspace) ResumeRoot() string {
+	// review: would we get better log messages if we resolved any symlinks first?
+	return filepath.Join(lm.workspaceRoot, ".timeless", "resume")
+}
 func (lm Workspace) MemoDir() string {
 	// review: would we get better log messages if we resolved any symlinks first?
 	return filepath.Join(lm.workspaceRoot, ".timeless", "memo")
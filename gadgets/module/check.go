@@ -0,0 +1,211 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/go-timeless-api/hitch"
+)
+
+// Problem is something wrong with a module, as found by Check.
+type Problem struct {
+	Step api.SubmoduleStepRef // Where the problem is: a step, or a submodule; zero for the module itself.
+	Msg  string
+}
+
+func (p Problem) String() string {
+	if p.Step.StepName == "" {
+		return "module: " + p.Msg
+	}
+	return fmt.Sprintf("step %s: %s", p.Step, p.Msg)
+}
+
+// Check statically checks a module for mistakes which would otherwise
+// only show up part way through evaluating it (or not at all):
+// references to slots that aren't in scope, from operation inputs, parent
+// imports of submodules, and exports; input and output paths which aren't
+// absolute, or collide; catalog imports which don't exist; and dependency
+// cycles.  All the problems found are returned, in a stable order.
+//
+// Catalog imports are looked up with viewLineageTool; if it's nil,
+// they aren't checked.
+func Check(ctx context.Context, mod api.Module, viewLineageTool hitch.ViewLineageTool) []Problem {
+	c := &checker{ctx: ctx, viewLineageTool: viewLineageTool}
+	c.checkModule(api.SubmoduleStepRef{}, "", mod)
+	// Refs to things that don't exist would also be reported by the ordering,
+	//  but less precisely; so only ask it about cycles.
+	if len(c.problems) == 0 {
+		if _, err := funcs.ModuleOrderStepsDeep(mod); err != nil {
+			c.problem(api.SubmoduleStepRef{}, "%s", err)
+		}
+	}
+	return c.problems
+}
+
+type checker struct {
+	ctx             context.Context
+	viewLineageTool hitch.ViewLineageTool
+	problems        []Problem
+}
+
+func (c *checker) problem(at api.SubmoduleStepRef, format string, args ...interface{}) {
+	c.problems = append(c.problems, Problem{at, fmt.Sprintf(format, args...)})
+}
+
+// checkModule checks a module, and recursively, its submodules.
+// The module is the step at (zero for the top level), whose steps are
+// at ctxPth.
+func (c *checker) checkModule(at api.SubmoduleStepRef, ctxPth api.SubmoduleRef, mod api.Module) {
+	for _, slotName := range sortedImportNames(mod.Imports) {
+		switch importRef := mod.Imports[slotName].(type) {
+		case api.ImportRef_Parent:
+			if at.StepName == "" {
+				c.problem(at, "import %q: parent import %q, but this is not a submodule", slotName, api.SlotRef(importRef))
+			}
+		case api.ImportRef_Catalog:
+			if err := c.checkCatalogImport(importRef); err != nil {
+				c.problem(at, "import %q: %s", slotName, err)
+			}
+		}
+	}
+	for _, stepName := range sortedStepNames(mod.Steps) {
+		stepRef := api.SubmoduleStepRef{ctxPth, stepName}
+		switch step := mod.Steps[stepName].(type) {
+		case api.Operation:
+			c.checkOperation(stepRef, mod, step)
+		case api.Module:
+			// Parent imports are references into the scope of this module.
+			for _, slotName := range sortedImportNames(step.Imports) {
+				if parentRef, ok := step.Imports[slotName].(api.ImportRef_Parent); ok {
+					if msg := scopeProblem(mod, api.SlotRef(parentRef)); msg != "" {
+						c.problem(stepRef, "import %q: parent import %q: %s", slotName, api.SlotRef(parentRef), msg)
+					}
+				}
+			}
+			c.checkModule(stepRef, ctxPth.Child(stepName), step)
+		}
+	}
+	itemNames := make([]string, 0, len(mod.Exports))
+	for itemName := range mod.Exports {
+		itemNames = append(itemNames, string(itemName))
+	}
+	sort.Strings(itemNames)
+	for _, itemName := range itemNames {
+		slotRef := mod.Exports[api.ItemName(itemName)]
+		if msg := scopeProblem(mod, slotRef); msg != "" {
+			c.problem(at, "export %q: %q: %s", itemName, slotRef, msg)
+		}
+	}
+}
+
+func (c *checker) checkOperation(stepRef api.SubmoduleStepRef, mod api.Module, op api.Operation) {
+	inputPaths := make([]string, 0, len(op.Inputs))
+	for pth := range op.Inputs {
+		inputPaths = append(inputPaths, string(pth))
+	}
+	sort.Strings(inputPaths)
+	cleaned := map[string]string{}
+	for _, pth := range inputPaths {
+		slotRef := op.Inputs[api.AbsPath(pth)]
+		if msg := scopeProblem(mod, slotRef); msg != "" {
+			c.problem(stepRef, "input %q: %q: %s", pth, slotRef, msg)
+		}
+		if !path.IsAbs(pth) {
+			c.problem(stepRef, "input %q: path should be absolute", pth)
+			continue
+		}
+		if other, ok := cleaned[path.Clean(pth)]; ok {
+			c.problem(stepRef, "input %q: same path as input %q", pth, other)
+		}
+		cleaned[path.Clean(pth)] = pth
+	}
+	slotNames := make([]string, 0, len(op.Outputs))
+	for slotName := range op.Outputs {
+		slotNames = append(slotNames, string(slotName))
+	}
+	sort.Strings(slotNames)
+	cleaned = map[string]string{}
+	for _, slotName := range slotNames {
+		pth := string(op.Outputs[api.SlotName(slotName)])
+		if !path.IsAbs(pth) {
+			c.problem(stepRef, "output %q: path %q should be absolute", slotName, pth)
+			continue
+		}
+		if other, ok := cleaned[path.Clean(pth)]; ok {
+			c.problem(stepRef, "output %q: path %q is also the path of output %q", slotName, pth, other)
+		}
+		cleaned[path.Clean(pth)] = slotName
+	}
+}
+
+func (c *checker) checkCatalogImport(ref api.ImportRef_Catalog) error {
+	if c.viewLineageTool == nil {
+		return nil
+	}
+	lin, err := c.viewLineageTool(c.ctx, ref.ModuleName)
+	if err != nil {
+		return err
+	}
+	rel, err := hitch.LineagePluckReleaseByName(*lin, ref.ReleaseName)
+	if err != nil {
+		return fmt.Errorf("module %s has no release %q", ref.ModuleName, ref.ReleaseName)
+	}
+	if _, ok := rel.Items[ref.ItemName]; !ok {
+		return fmt.Errorf("release %s:%s has no item %q", ref.ModuleName, ref.ReleaseName, ref.ItemName)
+	}
+	return nil
+}
+
+// scopeProblem says what's wrong with a reference to a slot in a module's
+// scope, or returns "" if nothing is.
+func scopeProblem(mod api.Module, ref api.SlotRef) string {
+	if ref.StepName == "" {
+		if _, ok := mod.Imports[ref.SlotName]; !ok {
+			return fmt.Sprintf("no import %q in module", ref.SlotName)
+		}
+		return ""
+	}
+	switch step := mod.Steps[ref.StepName].(type) {
+	case api.Operation:
+		if _, ok := step.Outputs[ref.SlotName]; !ok {
+			return fmt.Sprintf("step %q has no output %q", ref.StepName, ref.SlotName)
+		}
+	case api.Module:
+		if _, ok := step.Exports[api.ItemName(ref.SlotName)]; !ok {
+			return fmt.Sprintf("submodule %q has no export %q", ref.StepName, ref.SlotName)
+		}
+	default:
+		return fmt.Sprintf("no step %q in module", ref.StepName)
+	}
+	return ""
+}
+
+func sortedImportNames(imports map[api.SlotName]api.ImportRef) []api.SlotName {
+	names := make([]string, 0, len(imports))
+	for slotName := range imports {
+		names = append(names, string(slotName))
+	}
+	sort.Strings(names)
+	slotNames := make([]api.SlotName, len(names))
+	for i, name := range names {
+		slotNames[i] = api.SlotName(name)
+	}
+	return slotNames
+}
+
+func sortedStepNames(steps map[api.StepName]api.StepUnion) []api.StepName {
+	names := make([]string, 0, len(steps))
+	for stepName := range steps {
+		names = append(names, string(stepName))
+	}
+	sort.Strings(names)
+	stepNames := make([]api.StepName, len(names))
+	for i, name := range names {
+		stepNames[i] = api.StepName(name)
+	}
+	return stepNames
+}
@@ -0,0 +1,122 @@
+package module
+
+import (
+	"context"
+	"testing"
+
+	"github.com/polydawn/go-errcat"
+	. "github.com/warpfork/go-wish"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/hitch"
+)
+
+func TestCheck(t *testing.T) {
+	viewLineage := func(ctx context.Context, modName api.ModuleName) (*api.Lineage, error) {
+		if modName != "froob.org/base" {
+			return nil, errcat.Errorf(hitch.ErrNoSuchLineage, "no lineage for %q", modName)
+		}
+		return &api.Lineage{Name: modName, Releases: []api.Release{
+			{Name: "v1", Items: map[api.ItemName]api.WareID{"linux-amd64": {"tar", "aaaa"}}},
+		}}, nil
+	}
+	check := func(mod api.Module) []string {
+		msgs := []string{}
+		for _, problem := range Check(context.Background(), mod, viewLineage) {
+			msgs = append(msgs, problem.String())
+		}
+		return msgs
+	}
+
+	t.Run("a sound module should have no problems", func(t *testing.T) {
+		Wish(t, check(api.Module{
+			Imports: map[api.SlotName]api.ImportRef{
+				"base": api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+			},
+			Steps: map[api.StepName]api.StepUnion{
+				"build": api.Operation{
+					Inputs:  map[api.AbsPath]api.SlotRef{"/": {"", "base"}},
+					Outputs: map[api.SlotName]api.AbsPath{"out": "/task/out"},
+				},
+				"sub": api.Module{
+					Imports: map[api.SlotName]api.ImportRef{
+						"thing": api.ImportRef_Parent{"build", "out"},
+					},
+					Steps: map[api.StepName]api.StepUnion{
+						"pack": api.Operation{
+							Inputs:  map[api.AbsPath]api.SlotRef{"/": {"", "thing"}},
+							Outputs: map[api.SlotName]api.AbsPath{"out": "/task/out"},
+						},
+					},
+					Exports: map[api.ItemName]api.SlotRef{"packed": {"pack", "out"}},
+				},
+			},
+			Exports: map[api.ItemName]api.SlotRef{"packed": {"sub", "packed"}},
+		}), ShouldEqual, []string{})
+	})
+	t.Run("every problem should be reported where it occurs", func(t *testing.T) {
+		Wish(t, check(api.Module{
+			Imports: map[api.SlotName]api.ImportRef{
+				"base":   api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+				"nope":   api.ImportRef_Catalog{"froob.org/base", "v2", "linux-amd64"},
+				"parent": api.ImportRef_Parent{"build", "out"},
+			},
+			Steps: map[api.StepName]api.StepUnion{
+				"build": api.Operation{
+					Inputs: map[api.AbsPath]api.SlotRef{
+						"/":     {"", "base"},
+						"/src":  {"", "src"},
+						"/src/": {"", "base"},
+						"rel":   {"", "base"},
+					},
+					Outputs: map[api.SlotName]api.AbsPath{
+						"out": "/task/out",
+						"bin": "/task/out/",
+					},
+				},
+				"sub": api.Module{
+					Imports: map[api.SlotName]api.ImportRef{
+						"thing": api.ImportRef_Parent{"build", "lib"},
+					},
+					Steps: map[api.StepName]api.StepUnion{
+						"pack": api.Operation{
+							Inputs:  map[api.AbsPath]api.SlotRef{"/": {"", "thing"}},
+							Outputs: map[api.SlotName]api.AbsPath{"out": "/task/out"},
+						},
+					},
+					Exports: map[api.ItemName]api.SlotRef{"packed": {"pack", "nil"}},
+				},
+			},
+			Exports: map[api.ItemName]api.SlotRef{
+				"packed": {"sub", "unpacked"},
+				"other":  {"elsewhere", "out"},
+			},
+		}), ShouldEqual, []string{
+			`module: import "nope": module froob.org/base has no release "v2"`,
+			`module: import "parent": parent import "build.out", but this is not a submodule`,
+			`step build: input "/src": "src": no import "src" in module`,
+			`step build: input "/src/": same path as input "/src"`,
+			`step build: input "rel": path should be absolute`,
+			`step build: output "out": path "/task/out" is also the path of output "bin"`,
+			`step sub: import "thing": parent import "build.lib": step "build" has no output "lib"`,
+			`step sub: export "packed": "pack.nil": step "pack" has no output "nil"`,
+			`module: export "other": "elsewhere.out": no step "elsewhere" in module`,
+			`module: export "packed": "sub.unpacked": submodule "sub" has no export "unpacked"`,
+		})
+	})
+	t.Run("dependency cycles should be reported", func(t *testing.T) {
+		msgs := check(api.Module{
+			Steps: map[api.StepName]api.StepUnion{
+				"a": api.Operation{
+					Inputs:  map[api.AbsPath]api.SlotRef{"/": {"b", "out"}},
+					Outputs: map[api.SlotName]api.AbsPath{"out": "/out"},
+				},
+				"b": api.Operation{
+					Inputs:  map[api.AbsPath]api.SlotRef{"/": {"a", "out"}},
+					Outputs: map[api.SlotName]api.AbsPath{"out": "/out"},
+				},
+			},
+		})
+		Wish(t, len(msgs), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,79 @@
+package module
+
+import (
+	"strings"
+	"time"
+
+	"go.polydawn.net/go-timeless-api"
+)
+
+// EventSink receives structured notice of each operation step's progress
+// during Evaluate (in addition to the human-readable logs sent to the LogSink).
+//
+// Steps are fully contextualized (e.g. "submodule.step").
+// Implementations must be safe for concurrent use: when steps are evaluated
+// in parallel, events for several steps may arrive at once.
+type EventSink interface {
+	// StepResolved is called when a step's formula is fully resolved,
+	// just before it's handed to repeatr.
+	StepResolved(step api.SubmoduleStepRef, frm api.Formula)
+
+	// StepStarted is called when repeatr is invoked for a step.
+	StepStarted(step api.SubmoduleStepRef)
+
+	// StepOutput is called with each line of repeatr's monitor output for a step
+	// (as rendered by repeatrfmt, without the trailing newline).
+	StepOutput(step api.SubmoduleStepRef, line string)
+
+	// StepFinished is called when a step is done, whether it succeeded or not.
+	// If the step failed to resolve or run, record is nil and err is set;
+	// otherwise record is set (and may still have a non-zero exit code).
+	StepFinished(step api.SubmoduleStepRef, record *api.OperationRecord, err error, elapsed time.Duration)
+}
+
+type noopEventSink struct{}
+
+func (noopEventSink) StepResolved(api.SubmoduleStepRef, api.Formula)                                {}
+func (noopEventSink) StepStarted(api.SubmoduleStepRef)                                              {}
+func (noopEventSink) StepOutput(api.SubmoduleStepRef, string)                                       {}
+func (noopEventSink) StepFinished(api.SubmoduleStepRef, *api.OperationRecord, error, time.Duration) {}
+
+// stepOutputWriter is an io.Writer which passes each line to EventSink.StepOutput.
+// Wrap it in iofilter.LineBufferingWriter so it's called once per line.
+type stepOutputWriter struct {
+	events EventSink
+	step   api.SubmoduleStepRef
+}
+
+func (w stepOutputWriter) Write(b []byte) (int, error) {
+	w.events.StepOutput(w.step, strings.TrimSuffix(string(b), "\n"))
+	return len(b), nil
+}
+
+// TeeEventSink returns an EventSink which passes every event to each of the given sinks, in order.
+func TeeEventSink(sinks ...EventSink) EventSink {
+	return teeEventSink(sinks)
+}
+
+type teeEventSink []EventSink
+
+func (t teeEventSink) StepResolved(step api.SubmoduleStepRef, frm api.Formula) {
+	for _, s := range t {
+		s.StepResolved(step, frm)
+	}
+}
+func (t teeEventSink) StepStarted(step api.SubmoduleStepRef) {
+	for _, s := range t {
+		s.StepStarted(step)
+	}
+}
+func (t teeEventSink) StepOutput(step api.SubmoduleStepRef, line string) {
+	for _, s := range t {
+		s.StepOutput(step, line)
+	}
+}
+func (t teeEventSink) StepFinished(step api.SubmoduleStepRef, record *api.OperationRecord, err error, elapsed time.Duration) {
+	for _, s := range t {
+		s.StepFinished(step, record, err, elapsed)
+	}
+}
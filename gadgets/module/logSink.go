@@ -0,0 +1,50 @@
+package module
+
+import (
+	"io"
+	"sync"
+
+	"go.polydawn.net/go-timeless-api"
+)
+
+// LogSink is where Evaluate sends its logs.
+//
+// Implementations must be safe for concurrent use: when steps are evaluated
+// in parallel, several steps may be logging at once.
+type LogSink interface {
+	// Log returns the writer for messages about the evaluation as a whole,
+	// rather than any one step.
+	Log() io.Writer
+
+	// StepLog returns the writer for one operation step's log.
+	// The step is fully contextualized (e.g. "submodule.step").
+	// The returned func is called when the step is done with the writer,
+	// whether the step succeeded or not.
+	StepLog(step api.SubmoduleStepRef) (io.Writer, func())
+}
+
+// NewLogSink returns a LogSink which sends everything to a single writer.
+// Each call to Write is passed through whole, so it's safe to use with
+// parallel evaluation (which writes each step's log in one go).
+func NewLogSink(w io.Writer) LogSink {
+	return &writerLogSink{w: w}
+}
+
+type writerLogSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerLogSink) Log() io.Writer {
+	return s
+}
+
+func (s *writerLogSink) StepLog(api.SubmoduleStepRef) (io.Writer, func()) {
+	return s, func() {}
+}
+
+func (s *writerLogSink) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
@@ -1,9 +1,14 @@
 package module
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/polydawn/refmt"
 	"github.com/polydawn/refmt/json"
@@ -16,11 +21,67 @@ import (
 	"go.polydawn.net/reach/lib/iofilter"
 )
 
-// FUTURE: would be nice to have each step eval return futures, and then
-//  have a pool of 'worker' goroutines so we can have a '-j' param for actual run.
-//  Of course, it's hard to say what's sensible other than '-j=9999' once you
-//  throw e.g. a kubernetes cluster at it as a resource.  Threads are not the
-//  real resource to watch -- just a simpleton correlate.
+// EvalOptions holds the optional parameters to Evaluate.
+// The zero value is valid, and evaluates one step at a time.
+type EvalOptions struct {
+	// Maximum number of operations to run at once.
+	//  Zero (or less) means one: steps are evaluated strictly in order.
+	//  Threads are not the real resource to watch here -- it's hard to say
+	//  what's sensible once you throw e.g. a kubernetes cluster at it --
+	//  but it's a simple correlate.
+	Parallelism int
+
+	// Where to send logs.  If nil, logs are discarded.
+	Log LogSink
+
+	// Where to send structured progress events.  May be nil.
+	Events EventSink
+
+	// If true, a step failing doesn't halt the evaluation: steps which
+	// depend on it are skipped, and everything else is still evaluated.
+	// If anything failed, Evaluate returns whatever exports it could
+	// produce, together with a *JobFailures error.
+	KeepGoing bool
+
+	// Set if the order was filtered by PruneOrder.  Exports of the steps
+	// left out of it are then left out of the results, rather than being
+	// an error.
+	Pruned bool
+
+	// Results of operation steps already completed (e.g. by an earlier
+	// evaluation of the same module, with the same pins, which failed later).
+	// These steps aren't evaluated again: their results go straight into scope.
+	Completed map[api.SubmoduleStepRef]map[api.SlotName]api.WareID
+
+	// Maximum time each operation may run for.  Zero means no limit.
+	//  A step running out of time is a failure of that step, just like a
+	//  non-zero exit code.  (A deadline on the whole evaluation should be
+	//  set on the ctx instead; running out of that is a cancellation.)
+	StepTimeout time.Duration
+
+	// How to pack each output of each operation (steps being fully
+	//  contextualized).  If nil, outputs use operation.DefaultOutputSpec.
+	OutputSpec func(step api.SubmoduleStepRef, slot api.SlotName) api.FormulaOutputSpec
+}
+
+// JobFailures is the error returned by Evaluate with KeepGoing set,
+// when the evaluation went as far as it could, but some steps failed.
+type JobFailures struct {
+	Failed  []FailedStep
+	Skipped []api.SubmoduleStepRef // Steps not evaluated, because they depend on failed steps.
+}
+
+// FailedStep describes one failed step.
+// Either the step ran and exited non-zero, or Err says why it couldn't be run.
+type FailedStep struct {
+	Step     api.SubmoduleStepRef
+	ExitCode int
+	Err      error
+}
+
+func (e *JobFailures) Error() string {
+	return fmt.Sprintf("%d steps failed, and %d steps were skipped because they depend on failed steps", len(e.Failed), len(e.Skipped))
+}
 
 func Evaluate(
 	ctx context.Context,
@@ -30,128 +91,445 @@ func Evaluate(
 	wareSourcing api.WareSourcing,
 	wareStaging api.WareStaging,
 	runTool repeatr.RunFunc,
+	opts EvalOptions,
 ) (_ map[api.ItemName]api.WareID, err error) {
-	return evaluate(ctx, "", mod, order, map[api.SlotRef]api.WareID{}, pins, wareSourcing, wareStaging, runTool)
+	if opts.Parallelism < 1 {
+		opts.Parallelism = 1
+	}
+	if opts.Log == nil {
+		opts.Log = NewLogSink(ioutil.Discard)
+	}
+	if opts.Events == nil {
+		opts.Events = noopEventSink{}
+	}
+	// Any step failing cancels all the others.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e := &evaluator{
+		wareSourcing: wareSourcing,
+		wareStaging:  wareStaging,
+		runTool:      runTool,
+		serial:       opts.Parallelism == 1,
+		slots:        make(chan struct{}, opts.Parallelism),
+		log:          opts.Log,
+		events:       opts.Events,
+		cancel:       cancel,
+		keepGoing:    opts.KeepGoing,
+		pruned:       opts.Pruned,
+		completed:    opts.Completed,
+		stepTimeout:  opts.StepTimeout,
+		outputSpec:   opts.OutputSpec,
+	}
+	exports, err := e.evaluate(ctx, "", mod, order, map[api.SlotRef]api.WareID{}, pins)
+	if err != nil {
+		// Steps cancelled because some other step failed return the cancellation,
+		//  which may have reached the top first; the failure is what to report.
+		if e.halted != nil {
+			return nil, e.halted
+		}
+		return nil, err
+	}
+	if len(e.failures.Failed) > 0 {
+		sort.Slice(e.failures.Failed, func(i, j int) bool {
+			return e.failures.Failed[i].Step.String() < e.failures.Failed[j].Step.String()
+		})
+		sort.Slice(e.failures.Skipped, func(i, j int) bool {
+			return e.failures.Skipped[i].String() < e.failures.Skipped[j].String()
+		})
+		return exports, &e.failures
+	}
+	return exports, nil
+}
+
+// evaluator holds the state shared by every level of recursion in one Evaluate.
+type evaluator struct {
+	wareSourcing api.WareSourcing
+	wareStaging  api.WareStaging
+	runTool      repeatr.RunFunc
+
+	serial bool          // If true, each step is finished before the next is started.
+	slots  chan struct{} // Semaphore bounding how many operations run at once.
+
+	log    LogSink
+	events EventSink
+
+	cancel context.CancelFunc // Called on the first error, to stop all other steps.
+
+	keepGoing bool
+	mu        sync.Mutex
+	failures  JobFailures // Only used with keepGoing.
+	halted    error       // First error which halted the evaluation.  Not used with keepGoing.
+
+	pruned bool
+
+	completed   map[api.SubmoduleStepRef]map[api.SlotName]api.WareID
+	stepTimeout time.Duration
+	outputSpec  func(api.SubmoduleStepRef, api.SlotName) api.FormulaOutputSpec
+}
+
+// outputSpecs returns how to pack each output of an operation,
+// in the form operation.Resolve wants.
+func (e *evaluator) outputSpecs(stepRef api.SubmoduleStepRef, op api.Operation) map[api.SlotName]api.FormulaOutputSpec {
+	if e.outputSpec == nil {
+		return nil
+	}
+	specs := make(map[api.SlotName]api.FormulaOutputSpec, len(op.Outputs))
+	for slotName := range op.Outputs {
+		specs[slotName] = e.outputSpec(stepRef, slotName)
+	}
+	return specs
+}
+
+// levelState is the scope of one module during evaluation,
+// and the bookkeeping for which of its steps have finished.
+type levelState struct {
+	mu    sync.Mutex
+	scope map[api.SlotRef]api.WareID
+	done  map[api.StepName]chan struct{} // Closed when the step is done and its results are in scope.
+	err   error                          // First error from any step at this level.
+}
+
+func (ls *levelState) snapshot() map[api.SlotRef]api.WareID {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	scope := make(map[api.SlotRef]api.WareID, len(ls.scope))
+	for k, v := range ls.scope {
+		scope[k] = v
+	}
+	return scope
+}
+
+func (ls *levelState) fail(err error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.err == nil {
+		ls.err = err
+	}
 }
 
-func evaluate(
+func (e *evaluator) evaluate(
 	ctx context.Context,
 	ctxPth api.SubmoduleRef,
 	mod api.Module,
 	order funcs.StepTree,
 	parentScope map[api.SlotRef]api.WareID,
 	pins funcs.Pins,
-	wareSourcing api.WareSourcing,
-	wareStaging api.WareStaging,
-	runTool repeatr.RunFunc,
 ) (_ map[api.ItemName]api.WareID, err error) {
 	// Initialize map of locally scoped inputs.
-	scope := map[api.SlotRef]api.WareID{}
+	ls := &levelState{
+		scope: map[api.SlotRef]api.WareID{},
+		done:  map[api.StepName]chan struct{}{},
+	}
 	var ok bool
 	for slotName, importRef := range mod.Imports {
 		switch ref2 := importRef.(type) {
 		case api.ImportRef_Catalog: // catalog references should already be resolved and handed to us in the pins map.
-			scope[api.SlotRef{"", slotName}], ok = pins[api.SubmoduleSlotRef{"", api.SlotRef{"", slotName}}]
+			ls.scope[api.SlotRef{"", slotName}], ok = pins[api.SubmoduleSlotRef{"", api.SlotRef{"", slotName}}]
 			if !ok {
-				return nil, fmt.Errorf("missing pin for import %q in module %s", slotName, ctxPth)
+				return nil, e.halt(fmt.Errorf("missing pin for import %q in module %s", slotName, ctxPth))
 			}
 		case api.ImportRef_Parent: // parent references pluck something out of the parent scope.
-			scope[api.SlotRef{"", slotName}], ok = parentScope[api.SlotRef(ref2)]
+			ls.scope[api.SlotRef{"", slotName}], ok = parentScope[api.SlotRef(ref2)]
 			if !ok {
-				return nil, fmt.Errorf("missing pin for import %q in module %s", slotName, ctxPth)
+				return nil, e.halt(fmt.Errorf("missing pin for import %q in module %s", slotName, ctxPth))
 			}
 		case api.ImportRef_Ingest: // ingest references should *also* already be resolved and handed to us in the pins map.
-			scope[api.SlotRef{"", slotName}], ok = pins[api.SubmoduleSlotRef{"", api.SlotRef{"", slotName}}]
+			ls.scope[api.SlotRef{"", slotName}], ok = pins[api.SubmoduleSlotRef{"", api.SlotRef{"", slotName}}]
 			if !ok {
-				return nil, fmt.Errorf("missing pin for import %q in module %s", slotName, ctxPth)
+				return nil, e.halt(fmt.Errorf("missing pin for import %q in module %s", slotName, ctxPth))
 			}
 		}
 	}
-	// Loop over steps at this level.  Append scope map with each result.
+	for _, submStepRef := range order {
+		if submStepRef.SubmoduleRef == "" {
+			ls.done[submStepRef.StepName] = make(chan struct{})
+		}
+	}
+	// Start each step at this level, in order.
+	//  Each step waits for the steps it depends on, so the order only
+	//  matters in serial mode (where it's the whole plan).
+	var wg sync.WaitGroup
 	for _, submStepRef := range order {
 		if submStepRef.SubmoduleRef != "" {
 			continue // belongs to a deeper level, handled by recursion already
 		}
-		fmt.Fprintf(os.Stderr, "beginning evaluation of step %v: %v\n", ctxPth, submStepRef)
-		switch step := mod.Steps[submStepRef.StepName].(type) {
-		case api.Operation:
-			// Prepare highlighting box.
-			rawWriter := os.Stderr // FIXME is code smell to grab os.Stderr this deep down (though currently harmless)
-			fmt.Fprintf(rawWriter, "  \033[1;33m┌── step %s: resolving... ───────────────\033[0m\n", submStepRef)
-			printer := iofilter.LinePrefixingWriter(rawWriter, []byte("  \033[1;33m│\033[0m "))
-			// Resolve names into a PreparedOperation.
-			prop, err := operation.Resolve(
-				step,
-				scope,
-				wareSourcing,
-				wareStaging,
-			)
-			if err != nil {
-				fmt.Fprintf(rawWriter, "  \033[1;33m└───────────────\033[0m\n")
-				return nil, fmt.Errorf("failed resolving operation %q: %s", submStepRef.Contextualize(ctxPth), err)
-			}
-			// Print the resolved Formula -- useful for demo and debugging.
-			//  (But use a fork of the formula with a zero'd out action, because there's no need to reprint that!)
-			fmt.Fprintf(printer, "// resolved formula:\n")
-			logFrm := prop.Formula.Clone()
-			logFrm.Action = api.FormulaAction{Exec: []string{"..."}}
-			refmt.NewMarshallerAtlased(json.EncodeOptions{Line: []byte{'\n'}, Indent: []byte("    ")}, printer, api.Atlas_Formula).Marshal(logFrm)
-			fmt.Fprintf(rawWriter, "  \033[1;33m├── step %s: repeatr'ing... ────────\033[0m\n", submStepRef)
-			// Eval!
-			mon, monWaitCh := repeatrfmt.ServeMonitor(repeatrfmt.NewAnsiPrinter(printer, printer))
-			record, err := operation.Eval(
-				ctx,
-				runTool,
-				*prop,
-				repeatr.InputControl{}, // input control is always zero for build jobs.
-				mon,
-			)
-			close(mon.Chan)
-			<-monWaitCh
-			fmt.Fprintf(rawWriter, "  \033[1;33m└───────────────\033[0m\n")
-			if err != nil {
-				return nil, fmt.Errorf("failed evaluating operation %q: %s", submStepRef.Contextualize(ctxPth), err)
-			}
-			// Modify the names in scope to include the new outputs!
-			for slotName := range step.Outputs {
-				scope[api.SlotRef{submStepRef.StepName, slotName}] = record.Results[slotName]
-			}
-			if record.ExitCode != 0 {
-				// REVIEW: for the module, as with the operation alone: job unsuccessful isn't the same as eval error; make third return value?
-				return nil, fmt.Errorf("operation %q exit code %d -- eval halted", submStepRef.Contextualize(ctxPth), record.ExitCode)
-			}
-		case api.Module:
-			submoduleResults, err := evaluate(
-				ctx,
-				ctxPth.Child(submStepRef.StepName),
-				step,
-				order.DetachSubtree(submStepRef.StepName),
-				scope,
-				pins.DetachSubtree(submStepRef.StepName),
-				wareSourcing,
-				wareStaging,
-				runTool,
-			)
-			if err != nil {
-				return nil, err
+		submStepRef := submStepRef
+		run := func() {
+			defer wg.Done()
+			if err := e.evaluateStep(ctx, ctxPth, mod, order, ls, pins, submStepRef.StepName); err != nil {
+				ls.fail(err)
+				e.cancel()
 			}
-			for itemName := range step.Exports {
-				scope[api.SlotRef{submStepRef.StepName, api.SlotName(itemName)}] = submoduleResults[itemName]
+		}
+		wg.Add(1)
+		if e.serial {
+			run()
+			if ls.err != nil {
+				break
 			}
-		case nil:
-			panic("order incongruity")
-		default:
-			panic("unreachable")
+		} else {
+			go run()
 		}
 	}
+	wg.Wait()
+	if ls.err != nil {
+		return nil, ls.err
+	}
 	// Extract exports from local scope and return them under their export names.
+	//  If the order was pruned, exports of steps which were pruned from it are left out;
+	//  that includes submodule exports which the submodule left out in turn.
+	//  With keepGoing, so are exports of steps which failed or were skipped.
 	exportedResults := make(map[api.ItemName]api.WareID, len(mod.Exports))
 	for exportName, slotRef := range mod.Exports {
-		pin, ok := scope[slotRef]
+		pin, ok := ls.scope[slotRef]
+		if !ok && slotRef.StepName != "" {
+			_, ran := ls.done[slotRef.StepName]
+			_, isSubmodule := mod.Steps[slotRef.StepName].(api.Module)
+			if (e.pruned && (!ran || isSubmodule)) || e.keepGoing {
+				continue
+			}
+		}
 		if !ok {
-			panic(fmt.Errorf("module %q tries to use %s as an export but it is not in scope", ctxPth, slotRef))
+			return nil, fmt.Errorf("module %q tries to use %s as an export but it is not in scope", ctxPth, slotRef)
 		}
 		exportedResults[exportName] = pin
 	}
 	return exportedResults, nil
 }
+
+// evaluateStep waits for a step's dependencies, then evaluates it,
+// and puts its results in scope.
+func (e *evaluator) evaluateStep(
+	ctx context.Context,
+	ctxPth api.SubmoduleRef,
+	mod api.Module,
+	order funcs.StepTree,
+	ls *levelState,
+	pins funcs.Pins,
+	stepName api.StepName,
+) error {
+	submStepRef := api.SubmoduleStepRef{"", stepName}
+	for _, dep := range stepDependencies(mod.Steps[stepName]) {
+		select {
+		case <-ls.done[dep]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// With keepGoing, dependencies may be done but have failed, leaving their results out of scope.
+	//  If so, skip this step.
+	if e.keepGoing && !inputsInScope(mod.Steps[stepName], ls.snapshot()) {
+		fmt.Fprintf(e.log.Log(), "skipping step %v: %v, because it depends on failed steps\n", ctxPth, submStepRef)
+		e.mu.Lock()
+		e.failures.Skipped = append(e.failures.Skipped, submStepRef.Contextualize(ctxPth))
+		e.mu.Unlock()
+		close(ls.done[stepName])
+		return nil
+	}
+	switch step := mod.Steps[stepName].(type) {
+	case api.Operation:
+		// If the step was already completed, just put its results in scope.
+		if results, ok := e.completed[submStepRef.Contextualize(ctxPth)]; ok && hasAllOutputs(step, results) {
+			fmt.Fprintf(e.log.Log(), "step %v: %v already completed, reusing its results\n", ctxPth, submStepRef)
+			ls.mu.Lock()
+			for slotName := range step.Outputs {
+				ls.scope[api.SlotRef{stepName, slotName}] = results[slotName]
+			}
+			ls.mu.Unlock()
+			break
+		}
+		// Wait for a free slot.
+		select {
+		case e.slots <- struct{}{}:
+			defer func() { <-e.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		fullStepRef := submStepRef.Contextualize(ctxPth)
+		started := time.Now()
+		// Steps running in parallel buffer their log, and emit it all at once when done,
+		//  so it's not interleaved with anything else.
+		rawWriter, logDone := e.log.StepLog(fullStepRef)
+		defer logDone()
+		if !e.serial {
+			stepWriter, buf := rawWriter, &bytes.Buffer{}
+			defer func() { stepWriter.Write(buf.Bytes()) }()
+			rawWriter = buf
+		}
+		fmt.Fprintf(rawWriter, "beginning evaluation of step %v: %v\n", ctxPth, submStepRef)
+		// Prepare highlighting box.
+		fmt.Fprintf(rawWriter, "  \033[1;33m┌── step %s: resolving... ───────────────\033[0m\n", submStepRef)
+		printer := iofilter.LinePrefixingWriter(rawWriter, []byte("  \033[1;33m│\033[0m "))
+		// Resolve names into a PreparedOperation.
+		prop, err := operation.Resolve(
+			step,
+			ls.snapshot(),
+			e.wareSourcing,
+			e.wareStaging,
+			e.outputSpecs(fullStepRef, step),
+		)
+		if err != nil {
+			fmt.Fprintf(rawWriter, "  \033[1;33m└───────────────\033[0m\n")
+			err = fmt.Errorf("failed resolving operation %q: %s", fullStepRef, err)
+			e.events.StepFinished(fullStepRef, nil, err, time.Since(started))
+			return e.jobFailed(ls, stepName, FailedStep{fullStepRef, 0, err}, err)
+		}
+		e.events.StepResolved(fullStepRef, prop.Formula)
+		// Print the resolved Formula -- useful for demo and debugging.
+		//  (But use a fork of the formula with a zero'd out action, because there's no need to reprint that!)
+		fmt.Fprintf(printer, "// resolved formula:\n")
+		logFrm := prop.Formula.Clone()
+		logFrm.Action = api.FormulaAction{Exec: []string{"..."}}
+		refmt.NewMarshallerAtlased(json.EncodeOptions{Line: []byte{'\n'}, Indent: []byte("    ")}, printer, api.Atlas_Formula).Marshal(logFrm)
+		fmt.Fprintf(rawWriter, "  \033[1;33m├── step %s: repeatr'ing... ────────\033[0m\n", submStepRef)
+		// Eval!
+		//  Monitor output goes both to the log and, line by line, to the event sink.
+		monWriter := io.MultiWriter(printer, iofilter.LineBufferingWriter(stepOutputWriter{e.events, fullStepRef}))
+		mon, monWaitCh := repeatrfmt.ServeMonitor(repeatrfmt.NewAnsiPrinter(monWriter, monWriter))
+		e.events.StepStarted(fullStepRef)
+		stepCtx := ctx
+		if e.stepTimeout > 0 {
+			var cancel context.CancelFunc
+			stepCtx, cancel = context.WithTimeout(ctx, e.stepTimeout)
+			defer cancel()
+		}
+		record, err := operation.Eval(
+			stepCtx,
+			e.runTool,
+			*prop,
+			repeatr.InputControl{}, // input control is always zero for build jobs.
+			mon,
+		)
+		close(mon.Chan)
+		<-monWaitCh
+		fmt.Fprintf(rawWriter, "  \033[1;33m└───────────────\033[0m\n")
+		if err != nil && ctx.Err() == nil && stepCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("operation %q timed out after %s", fullStepRef, e.stepTimeout)
+			e.events.StepFinished(fullStepRef, nil, err, time.Since(started))
+			return e.jobFailed(ls, stepName, FailedStep{fullStepRef, 0, err}, err)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed evaluating operation %q: %s", fullStepRef, err)
+			e.events.StepFinished(fullStepRef, nil, err, time.Since(started))
+			if ctx.Err() != nil {
+				return err // cancelled; not a failure of this step.
+			}
+			return e.jobFailed(ls, stepName, FailedStep{fullStepRef, 0, err}, err)
+		}
+		e.events.StepFinished(fullStepRef, record, nil, time.Since(started))
+		if record.ExitCode != 0 {
+			return e.jobFailed(ls, stepName, FailedStep{fullStepRef, record.ExitCode, nil},
+				fmt.Errorf("operation %q exit code %d -- eval halted", fullStepRef, record.ExitCode))
+		}
+		// Modify the names in scope to include the new outputs!
+		ls.mu.Lock()
+		for slotName := range step.Outputs {
+			ls.scope[api.SlotRef{stepName, slotName}] = record.Results[slotName]
+		}
+		ls.mu.Unlock()
+	case api.Module:
+		fmt.Fprintf(e.log.Log(), "beginning evaluation of step %v: %v\n", ctxPth, submStepRef)
+		submoduleResults, err := e.evaluate(
+			ctx,
+			ctxPth.Child(stepName),
+			step,
+			order.DetachSubtree(stepName),
+			ls.snapshot(),
+			pins.DetachSubtree(stepName),
+		)
+		if err != nil {
+			return err
+		}
+		ls.mu.Lock()
+		for itemName, wareID := range submoduleResults {
+			ls.scope[api.SlotRef{stepName, api.SlotName(itemName)}] = wareID
+		}
+		ls.mu.Unlock()
+	case nil:
+		panic("order incongruity")
+	default:
+		panic("unreachable")
+	}
+	close(ls.done[stepName])
+	return nil
+}
+
+// jobFailed handles a step failing.  Without keepGoing, that halts the
+// evaluation, so the error is simply returned.  With keepGoing, the failure
+// is recorded, and the step is marked done (with no results in scope, so
+// steps depending on it will be skipped).
+func (e *evaluator) jobFailed(ls *levelState, stepName api.StepName, failure FailedStep, err error) error {
+	if !e.keepGoing {
+		return e.halt(err)
+	}
+	e.mu.Lock()
+	e.failures.Failed = append(e.failures.Failed, failure)
+	e.mu.Unlock()
+	close(ls.done[stepName])
+	return nil
+}
+
+// halt records the error which is halting the evaluation (unless one already
+// was), so that it's what Evaluate returns, rather than the cancellation of
+// whatever other steps were running at the time.
+func (e *evaluator) halt(err error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.halted == nil {
+		e.halted = err
+	}
+	return err
+}
+
+// hasAllOutputs returns true if the results include every output of the operation.
+func hasAllOutputs(op api.Operation, results map[api.SlotName]api.WareID) bool {
+	for slotName := range op.Outputs {
+		if _, ok := results[slotName]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// inputsInScope returns true if everything a step reads from its siblings
+// (or its own module's imports) is in scope.
+func inputsInScope(step api.StepUnion, scope map[api.SlotRef]api.WareID) bool {
+	switch step2 := step.(type) {
+	case api.Operation:
+		for _, slotRef := range step2.Inputs {
+			if _, ok := scope[slotRef]; !ok {
+				return false
+			}
+		}
+	case api.Module:
+		for _, importRef := range step2.Imports {
+			if parentRef, ok := importRef.(api.ImportRef_Parent); ok {
+				if _, ok := scope[api.SlotRef(parentRef)]; !ok {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// stepDependencies returns the names of the sibling steps a step reads from.
+// (Names may repeat; that's harmless.)
+func stepDependencies(step api.StepUnion) []api.StepName {
+	var deps []api.StepName
+	switch step2 := step.(type) {
+	case api.Operation:
+		for _, slotRef := range step2.Inputs {
+			if slotRef.StepName != "" {
+				deps = append(deps, slotRef.StepName)
+			}
+		}
+	case api.Module:
+		for _, importRef := range step2.Imports {
+			if parentRef, ok := importRef.(api.ImportRef_Parent); ok && parentRef.StepName != "" {
+				deps = append(deps, parentRef.StepName)
+			}
+		}
+	}
+	return deps
+}
@@ -0,0 +1,75 @@
+package module
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/warpfork/go-wish"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+	"go.polydawn.net/go-timeless-api/repeatr"
+)
+
+func TestEvaluateParallelFailure(t *testing.T) {
+	op := func(cmd string) api.Operation {
+		return api.Operation{
+			Inputs:  map[api.AbsPath]api.SlotRef{"/": {"", "base"}},
+			Action:  api.FormulaAction{Exec: []string{cmd}},
+			Outputs: map[api.SlotName]api.AbsPath{"out": "/task/out"},
+		}
+	}
+	mod := api.Module{
+		Imports: map[api.SlotName]api.ImportRef{
+			"base": api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+		},
+		Steps: map[api.StepName]api.StepUnion{
+			"slow": op("wait"),
+			"sub": api.Module{
+				Imports: map[api.SlotName]api.ImportRef{
+					"base": api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+				},
+				Steps: map[api.StepName]api.StepUnion{
+					"boom": op("fail"),
+				},
+				Exports: map[api.ItemName]api.SlotRef{"out": {"boom", "out"}},
+			},
+		},
+		Exports: map[api.ItemName]api.SlotRef{
+			"slow": {"slow", "out"},
+			"out":  {"sub", "out"},
+		},
+	}
+	base := api.WareID{"tar", "aaaa"}
+	pins := funcs.Pins{
+		{"", api.SlotRef{"", "base"}}:    base,
+		{"sub", api.SlotRef{"", "base"}}: base,
+	}
+	// "wait" steps only finish when cancelled; "fail" steps exit non-zero at once.
+	runTool := func(ctx context.Context, frm api.Formula, _ repeatr.FormulaContext, _ repeatr.InputControl, _ repeatr.Monitor) (*api.FormulaRunRecord, error) {
+		if frm.Action.Exec[0] == "wait" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &api.FormulaRunRecord{ExitCode: 2, Results: map[api.AbsPath]api.WareID{}}, nil
+	}
+	ord, err := funcs.ModuleOrderStepsDeep(mod)
+	Wish(t, err, ShouldEqual, nil)
+
+	t.Run("the failing submodule step should be reported, not the cancellation", func(t *testing.T) {
+		_, err := Evaluate(
+			context.Background(),
+			mod,
+			ord,
+			pins,
+			api.WareSourcing{},
+			api.WareStaging{ByPackType: map[api.PackType]api.WarehouseLocation{"tar": "ca+file:///nowhere"}},
+			runTool,
+			EvalOptions{Parallelism: 2},
+		)
+		Wish(t, err != nil, ShouldEqual, true)
+		Wish(t, strings.Contains(err.Error(), `exit code 2 -- eval halted`), ShouldEqual, true)
+		Wish(t, strings.Contains(err.Error(), "context canceled"), ShouldEqual, false)
+	})
+}
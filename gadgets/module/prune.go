@@ -0,0 +1,162 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+
+	"go.polydawn.net/go-timeless-api"
+)
+
+// ParseStepRef parses a step ref as printed in evaluation plans:
+// the step name, prefixed by the path of submodules containing it, if any
+// (e.g. "step" or "submodule.step").
+func ParseStepRef(s string) api.SubmoduleStepRef {
+	i := strings.LastIndex(s, ".")
+	if i < 0 {
+		return api.SubmoduleStepRef{"", api.StepName(s)}
+	}
+	return api.SubmoduleStepRef{api.SubmoduleRef(s[:i]), api.StepName(s[i+1:])}
+}
+
+// PruneOrder filters a step order (as from funcs.ModuleOrderStepsDeep)
+// down to just the steps needed for the given steps and exports of the
+// module: the targets themselves, and everything they transitively depend
+// on, across submodules.
+//
+// Targeting a submodule step targets the whole submodule; but a dependency
+// on one of a submodule's exports only keeps the submodule steps needed
+// for that export.
+//
+// Evaluating a pruned order leaves exports of the pruned steps unset.
+func PruneOrder(
+	mod api.Module,
+	order []api.SubmoduleStepRef,
+	steps []api.SubmoduleStepRef,
+	exports []api.ItemName,
+) ([]api.SubmoduleStepRef, error) {
+	p := pruner{}
+	for _, target := range steps {
+		if _, err := LookupStep(mod, target); err != nil {
+			return nil, err
+		}
+		submod := p.needEnclosing(mod, target.SubmoduleRef)
+		p.needStep(target.SubmoduleRef, submod, target.StepName)
+	}
+	for _, itemName := range exports {
+		slotRef, ok := mod.Exports[itemName]
+		if !ok {
+			return nil, fmt.Errorf("no export %q in module", itemName)
+		}
+		p.needSlot("", mod, slotRef)
+	}
+	pruned := []api.SubmoduleStepRef{}
+	for _, stepRef := range order {
+		if _, ok := p[stepRef]; ok {
+			pruned = append(pruned, stepRef)
+		}
+	}
+	return pruned, nil
+}
+
+// LookupStep returns the step a (fully contextualized) step ref refers to.
+func LookupStep(mod api.Module, ref api.SubmoduleStepRef) (api.StepUnion, error) {
+	submod, err := findSubmodule(mod, ref.SubmoduleRef)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := submod.Steps[ref.StepName]
+	if !ok {
+		return nil, fmt.Errorf("no step %q in module", ref)
+	}
+	return step, nil
+}
+
+// findSubmodule walks down a path of submodule steps.
+func findSubmodule(mod api.Module, submRef api.SubmoduleRef) (*api.Module, error) {
+	if submRef == "" {
+		return &mod, nil
+	}
+	for _, stepName := range strings.Split(string(submRef), ".") {
+		submod, ok := mod.Steps[api.StepName(stepName)].(api.Module)
+		if !ok {
+			return nil, fmt.Errorf("no submodule %q in module", submRef)
+		}
+		mod = submod
+	}
+	return &mod, nil
+}
+
+// pruner accumulates the set of needed steps, by full step ref.
+//
+// Refs within the module are assumed to be valid;
+// funcs.ModuleOrderStepsDeep will already have rejected any that aren't.
+type pruner map[api.SubmoduleStepRef]struct{}
+
+// needStep marks a step (of the module at ctxPth) and its dependencies as needed.
+func (p pruner) needStep(ctxPth api.SubmoduleRef, mod api.Module, stepName api.StepName) {
+	ref := api.SubmoduleStepRef{ctxPth, stepName}
+	switch step := mod.Steps[stepName].(type) {
+	case api.Operation:
+		if _, ok := p[ref]; ok {
+			return
+		}
+		p[ref] = struct{}{}
+		for _, slotRef := range step.Inputs {
+			p.needSlot(ctxPth, mod, slotRef)
+		}
+	case api.Module:
+		// The submodule step may already be needed for some of its exports;
+		//  we still need to go through all of its steps now.
+		p.needSubmodule(ctxPth, mod, stepName, step)
+		for subStepName := range step.Steps {
+			p.needStep(ctxPth.Child(stepName), step, subStepName)
+		}
+	}
+}
+
+// needEnclosing marks the submodule steps enclosing the module at ctxPth as
+// needed, from the outermost down (the evaluation of a submodule's steps
+// happens within the evaluation of its submodule step), and returns that
+// module.  The path must be valid.
+func (p pruner) needEnclosing(mod api.Module, ctxPth api.SubmoduleRef) api.Module {
+	if ctxPth == "" {
+		return mod
+	}
+	parentPth := api.SubmoduleRef("")
+	for _, stepName := range strings.Split(string(ctxPth), ".") {
+		submod := mod.Steps[api.StepName(stepName)].(api.Module)
+		p.needSubmodule(parentPth, mod, api.StepName(stepName), submod)
+		parentPth, mod = parentPth.Child(api.StepName(stepName)), submod
+	}
+	return mod
+}
+
+// needSlot marks whatever step (of the module at ctxPth) produces a slot as needed.
+func (p pruner) needSlot(ctxPth api.SubmoduleRef, mod api.Module, slotRef api.SlotRef) {
+	if slotRef.StepName == "" {
+		return // an import; always available.
+	}
+	switch step := mod.Steps[slotRef.StepName].(type) {
+	case api.Operation:
+		p.needStep(ctxPth, mod, slotRef.StepName)
+	case api.Module:
+		// Only the part of the submodule that produces this export is needed.
+		p.needSubmodule(ctxPth, mod, slotRef.StepName, step)
+		p.needSlot(ctxPth.Child(slotRef.StepName), step, step.Exports[api.ItemName(slotRef.SlotName)])
+	}
+}
+
+// needSubmodule marks a submodule step as needed, along with whatever its
+// parent imports read from its siblings (which it always waits for).
+func (p pruner) needSubmodule(ctxPth api.SubmoduleRef, mod api.Module, stepName api.StepName, submod api.Module) {
+	ref := api.SubmoduleStepRef{ctxPth, stepName}
+	if _, ok := p[ref]; ok {
+		return
+	}
+	p[ref] = struct{}{}
+	for _, importRef := range submod.Imports {
+		if parentRef, ok := importRef.(api.ImportRef_Parent); ok {
+			p.needSlot(ctxPth, mod, api.SlotRef(parentRef))
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package module
+
+import (
+	"testing"
+
+	. "github.com/warpfork/go-wish"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/funcs"
+)
+
+func TestPruneOrder(t *testing.T) {
+	// Same shape as the module in examples/recursivemodule.
+	op := func(inputs ...api.SlotRef) api.Operation {
+		op := api.Operation{
+			Inputs:  map[api.AbsPath]api.SlotRef{},
+			Outputs: map[api.SlotName]api.AbsPath{"out": "/task/out"},
+		}
+		for i, slotRef := range inputs {
+			op.Inputs[api.AbsPath("/task/"+string(rune('a'+i)))] = slotRef
+		}
+		return op
+	}
+	mod := api.Module{
+		Imports: map[api.SlotName]api.ImportRef{
+			"base": api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+		},
+		Steps: map[api.StepName]api.StepUnion{
+			"step-first": op(api.SlotRef{"", "base"}),
+			"submodule-jamboree": api.Module{
+				Imports: map[api.SlotName]api.ImportRef{
+					"image":  api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+					"thingy": api.ImportRef_Parent{"step-first", "out"},
+				},
+				Steps: map[api.StepName]api.StepUnion{
+					"boop": op(api.SlotRef{"", "image"}, api.SlotRef{"", "thingy"}),
+					"bap":  op(api.SlotRef{"", "image"}),
+				},
+				Exports: map[api.ItemName]api.SlotRef{
+					"frob": {"boop", "out"},
+					"snoz": {"bap", "out"},
+				},
+			},
+			"step-after": op(api.SlotRef{"", "base"}, api.SlotRef{"submodule-jamboree", "snoz"}),
+			"step-aside": op(api.SlotRef{"", "base"}),
+		},
+		Exports: map[api.ItemName]api.SlotRef{
+			"product": {"step-after", "out"},
+			"aside":   {"step-aside", "out"},
+			"frob":    {"submodule-jamboree", "frob"},
+		},
+	}
+	ord, err := funcs.ModuleOrderStepsDeep(mod)
+	Wish(t, err, ShouldEqual, nil)
+	prune := func(steps []string, exports ...api.ItemName) []string {
+		stepRefs := []api.SubmoduleStepRef{}
+		for _, s := range steps {
+			stepRefs = append(stepRefs, ParseStepRef(s))
+		}
+		pruned, err := PruneOrder(mod, ord, stepRefs, exports)
+		Wish(t, err, ShouldEqual, nil)
+		strs := []string{}
+		for _, stepRef := range pruned {
+			strs = append(strs, stepRef.String())
+		}
+		return strs
+	}
+
+	t.Run("targeting a step keeps only its dependencies", func(t *testing.T) {
+		Wish(t, prune([]string{"step-aside"}), ShouldEqual, []string{"step-aside"})
+	})
+	t.Run("targeting a submodule step keeps what its submodule reads from the parent", func(t *testing.T) {
+		Wish(t, prune([]string{"submodule-jamboree.bap"}), ShouldEqual, []string{
+			"step-first",
+			"submodule-jamboree",
+			"submodule-jamboree.bap",
+		})
+	})
+	t.Run("depending on a submodule export keeps only the steps for that export", func(t *testing.T) {
+		pruned := prune(nil, "product")
+		Wish(t, len(pruned), ShouldEqual, 4)
+		Wish(t, contains(pruned, "submodule-jamboree.bap"), ShouldEqual, true)
+		Wish(t, contains(pruned, "submodule-jamboree.boop"), ShouldEqual, false)
+		Wish(t, contains(pruned, "step-aside"), ShouldEqual, false)
+	})
+	t.Run("targeting a whole submodule keeps all of it", func(t *testing.T) {
+		pruned := prune([]string{"submodule-jamboree"})
+		Wish(t, len(pruned), ShouldEqual, 4)
+		Wish(t, contains(pruned, "submodule-jamboree.boop"), ShouldEqual, true)
+	})
+	t.Run("unknown targets are rejected", func(t *testing.T) {
+		_, err := PruneOrder(mod, ord, []api.SubmoduleStepRef{ParseStepRef("nope.bap")}, nil)
+		Wish(t, err != nil, ShouldEqual, true)
+		_, err = PruneOrder(mod, ord, nil, []api.ItemName{"nope"})
+		Wish(t, err != nil, ShouldEqual, true)
+	})
+}
+
+func contains(strs []string, s string) bool {
+	for _, s2 := range strs {
+		if s2 == s {
+			return true
+		}
+	}
+	return false
+}
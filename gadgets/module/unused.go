@@ -0,0 +1,105 @@
+package module
+
+import (
+	"fmt"
+	"sort"
+
+	"go.polydawn.net/go-timeless-api"
+)
+
+// Unused finds the parts of a module which contribute nothing to it:
+// imports which nothing uses, operation outputs which nothing reads or
+// exports, exports of submodules which their parent doesn't use, and
+// whole steps which contribute to none of the module's exports.
+//
+// Steps which contribute to no export are reported just once: a dead
+// submodule's own steps aren't reported too, nor are the unused outputs
+// of dead steps.
+//
+// The module should have no problems according to Check.
+func Unused(mod api.Module) []Problem {
+	live := pruner{}
+	for _, slotRef := range mod.Exports {
+		live.needSlot("", mod, slotRef)
+	}
+	u := &unusedFinder{live: live}
+	u.checkModule(api.SubmoduleStepRef{}, "", mod)
+	return u.problems
+}
+
+type unusedFinder struct {
+	live     pruner // The steps contributing to some export.
+	problems []Problem
+}
+
+// checkModule checks a module, and recursively, its live submodules.
+// The module is the step at (zero for the top level), whose steps are
+// at ctxPth.
+func (u *unusedFinder) checkModule(at api.SubmoduleStepRef, ctxPth api.SubmoduleRef, mod api.Module) {
+	used := usedSlots(mod)
+	for _, slotName := range sortedImportNames(mod.Imports) {
+		if !used[api.SlotRef{"", slotName}] {
+			u.add(at, "import %q is not used", slotName)
+		}
+	}
+	for _, stepName := range sortedStepNames(mod.Steps) {
+		stepRef := api.SubmoduleStepRef{ctxPth, stepName}
+		if _, ok := u.live[stepRef]; !ok {
+			u.add(stepRef, "step contributes to no export")
+			continue
+		}
+		switch step := mod.Steps[stepName].(type) {
+		case api.Operation:
+			slotNames := make([]string, 0, len(step.Outputs))
+			for slotName := range step.Outputs {
+				slotNames = append(slotNames, string(slotName))
+			}
+			sort.Strings(slotNames)
+			for _, slotName := range slotNames {
+				if !used[api.SlotRef{stepName, api.SlotName(slotName)}] {
+					u.add(stepRef, "output %q is not used", slotName)
+				}
+			}
+		case api.Module:
+			itemNames := make([]string, 0, len(step.Exports))
+			for itemName := range step.Exports {
+				itemNames = append(itemNames, string(itemName))
+			}
+			sort.Strings(itemNames)
+			for _, itemName := range itemNames {
+				if !used[api.SlotRef{stepName, api.SlotName(itemName)}] {
+					u.add(stepRef, "export %q is not used", itemName)
+				}
+			}
+			u.checkModule(stepRef, ctxPth.Child(stepName), step)
+		}
+	}
+}
+
+func (u *unusedFinder) add(at api.SubmoduleStepRef, format string, args ...interface{}) {
+	u.problems = append(u.problems, Problem{at, fmt.Sprintf(format, args...)})
+}
+
+// usedSlots returns the set of slots in a module's scope which are read:
+// by operation inputs, submodules' parent imports, or exports.
+func usedSlots(mod api.Module) map[api.SlotRef]bool {
+	used := map[api.SlotRef]bool{}
+	for _, step := range mod.Steps {
+		switch step := step.(type) {
+		case api.Operation:
+			for _, slotRef := range step.Inputs {
+				used[slotRef] = true
+			}
+		case api.Module:
+			for _, importRef := range step.Imports {
+				if parentRef, ok := importRef.(api.ImportRef_Parent); ok {
+					used[api.SlotRef(parentRef)] = true
+				}
+			}
+		}
+	}
+	for _, slotRef := range mod.Exports {
+		used[slotRef] = true
+	}
+	return used
+}
@@ -0,0 +1,95 @@
+package module
+
+import (
+	"testing"
+
+	. "github.com/warpfork/go-wish"
+
+	"go.polydawn.net/go-timeless-api"
+)
+
+func TestUnused(t *testing.T) {
+	op := func(outputs []api.SlotName, inputs ...api.SlotRef) api.Operation {
+		op := api.Operation{
+			Inputs:  map[api.AbsPath]api.SlotRef{},
+			Outputs: map[api.SlotName]api.AbsPath{},
+		}
+		for i, slotRef := range inputs {
+			op.Inputs[api.AbsPath("/task/in"+string(rune('a'+i)))] = slotRef
+		}
+		for _, slotName := range outputs {
+			op.Outputs[slotName] = api.AbsPath("/task/" + string(slotName))
+		}
+		return op
+	}
+	out := []api.SlotName{"out"}
+	unused := func(mod api.Module) []string {
+		msgs := []string{}
+		for _, problem := range Unused(mod) {
+			msgs = append(msgs, problem.String())
+		}
+		return msgs
+	}
+
+	t.Run("a module where everything counts should have nothing unused", func(t *testing.T) {
+		Wish(t, unused(api.Module{
+			Imports: map[api.SlotName]api.ImportRef{
+				"base": api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+			},
+			Steps: map[api.StepName]api.StepUnion{
+				"build": op(out, api.SlotRef{"", "base"}),
+				"test":  op(out, api.SlotRef{"", "base"}, api.SlotRef{"build", "out"}),
+			},
+			Exports: map[api.ItemName]api.SlotRef{
+				"bin":    {"build", "out"},
+				"report": {"test", "out"},
+			},
+		}), ShouldEqual, []string{})
+	})
+	t.Run("unused imports, outputs, exports, and steps should each be reported", func(t *testing.T) {
+		Wish(t, unused(api.Module{
+			Imports: map[api.SlotName]api.ImportRef{
+				"base":  api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+				"extra": api.ImportRef_Catalog{"froob.org/base", "v1", "src"},
+			},
+			Steps: map[api.StepName]api.StepUnion{
+				"build": op([]api.SlotName{"out", "log"}, api.SlotRef{"", "base"}),
+				"lint":  op(out, api.SlotRef{"", "base"}),
+				"docs":  op(out, api.SlotRef{"lint", "out"}),
+				"sub": api.Module{
+					Imports: map[api.SlotName]api.ImportRef{
+						"bin":   api.ImportRef_Parent{"build", "out"},
+						"image": api.ImportRef_Catalog{"froob.org/base", "v1", "linux-amd64"},
+					},
+					Steps: map[api.StepName]api.StepUnion{
+						"pack":  op(out, api.SlotRef{"", "bin"}),
+						"probe": op(out, api.SlotRef{"", "bin"}),
+					},
+					Exports: map[api.ItemName]api.SlotRef{
+						"packed": {"pack", "out"},
+						"probed": {"probe", "out"},
+					},
+				},
+				"idle": api.Module{
+					Steps: map[api.StepName]api.StepUnion{
+						"spin": op(out),
+					},
+					Exports: map[api.ItemName]api.SlotRef{"spun": {"spin", "out"}},
+				},
+			},
+			Exports: map[api.ItemName]api.SlotRef{
+				"bin":    {"build", "out"},
+				"packed": {"sub", "packed"},
+			},
+		}), ShouldEqual, []string{
+			`module: import "extra" is not used`,
+			`step build: output "log" is not used`,
+			`step docs: step contributes to no export`,
+			`step idle: step contributes to no export`,
+			`step lint: step contributes to no export`,
+			`step sub: export "probed" is not used`,
+			`step sub: import "image" is not used`,
+			`step sub.probe: step contributes to no export`,
+		})
+	})
+}
@@ -7,6 +7,10 @@ import (
 	"go.polydawn.net/go-timeless-api/repeatr"
 )
 
+// DefaultOutputSpec is how operation outputs are packed,
+// unless configured otherwise: as tar, with repeatr's default filters.
+var DefaultOutputSpec = api.FormulaOutputSpec{PackType: "tar"}
+
 // Resolve takes an api.Operation, the local scoped names, and all applicable
 // ware sourcing and staging config, and turns them into a PreparedOperation.
 //
@@ -33,12 +37,19 @@ import (
 // that are the most reusable: thus, although the repeatr layer supports a
 // wider set of options, here, only content-addressable warehouses, indexed by
 // packType, are allowed as WareStaging arguments.
+// It's an error for an output to use a packType WareStaging has no
+// warehouse for.
+//
+// The api.Operation layer doesn't say how its outputs should be packed
+// (that's a matter of how reproducible the build is, rather than what
+// it does), so that's given separately, per output slot.
 //
 func Resolve(
 	op api.Operation, // What protype of a formula to bind and run.
 	scope map[api.SlotRef]api.WareID, // What slots are in scope to reference as inputs.
 	wareSourcing api.WareSourcing, // Suggestions on where to get wares.
 	wareStaging api.WareStaging, // Instructions on where to store output wares.
+	outputSpecs map[api.SlotName]api.FormulaOutputSpec, // How to pack each output; any not listed use DefaultOutputSpec.
 ) (*PreparedOperation, error) {
 	// Initialize everything we're about to fill in.
 	//  (The Formula.Action just comes along, unchanged.)
@@ -67,10 +78,14 @@ func Resolve(
 	// Fill in outputs in Repeatr format.
 	//  Save the reverse mappings back into slotnames as we go; we'll need these later.
 	for slotName, pth := range op.Outputs {
-		prop.Formula.Outputs[pth] = api.FormulaOutputSpec{
-			PackType: "tar", // FUTURE: the api.Operation layer doesn't really support config for this yet, but should
-			// FUTURE: filters are also conspicuously missing at the api.Operation layer, and should be included
+		outSpec, ok := outputSpecs[slotName]
+		if !ok {
+			outSpec = DefaultOutputSpec
+		}
+		if _, ok := wareStaging.ByPackType[outSpec.PackType]; !ok {
+			return nil, fmt.Errorf("cannot save output %q: no warehouse is configured for pack type %q", slotName, outSpec.PackType)
 		}
+		prop.Formula.Outputs[pth] = outSpec
 		prop.OutputReverseMap[pth] = slotName
 	}
 
@@ -0,0 +1,142 @@
+package workspace
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/polydawn/refmt"
+	"github.com/polydawn/refmt/json"
+	"github.com/polydawn/refmt/obj/atlas"
+	"github.com/warpfork/go-errcat"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/reach/gadgets/layout"
+)
+
+type ErrorCategory string
+
+const (
+	ConfigLoadError = ErrorCategory("reach-workspace-config-error")
+)
+
+// Config is the parsed content of a workspace config file
+// (see `layout.Workspace.WorkspaceConfigFile()`).
+//
+// All fields are optional; the zero value describes a workspace which uses
+// all the default paths under the '.timeless' dir.
+//
+// Paths in the config may be absolute, or relative; relative paths are
+// interpreted relative to the workspace root (not the '.timeless' dir,
+// and not the cwd).
+type Config struct {
+	// Path of the local warehouse where produced wares are stored.
+	// Default is ".timeless/warehouse".
+	StagingWarehouse string
+
+	// Path of the dir repeatr uses for memoizing formula results.
+	// Default is ".timeless/memo".
+	MemoDir string
+
+	// Paths of additional catalog trees to read from.
+	// The workspace's own catalog is always consulted first;
+	// these are probed in order after it, and are never written to.
+	CatalogRoots []string
+
+	// Mapping of paths within the workspace to module names.
+	// Keys are path patterns; values are module name prefixes.
+	Modules map[string]string
+
+	// Name of the module in the workspace root dir (if there is one).
+	// Default is "unnamed".
+	RootModule api.ModuleName
+
+	// Name of the saga to record candidate releases in, when none is
+	// given by flags or env.  Default is "default".
+	Saga string
+
+	// Replacements for catalog imports, applied to every module evaluated
+	// in the workspace (see hitch.Replacement in gadgets/catalog/hitch).
+	// Keys are "catalog:$module:$release" or "catalog:$module:$release:$item";
+	// values are "catalog:$module:$release" (e.g. "catalog:$module:candidate",
+	// to use a module being developed in the workspace), or a WareID, if the
+	// key names a single item.
+	Replace map[string]string
+
+	// Default time limits for module evaluation, as durations like "90m".
+	// Timeout is for the whole evaluation of a module; StepTimeout for each
+	// operation in it.  Default is no limit.  Flags take precedence.
+	Timeout     string
+	StepTimeout string
+
+	// How operations pack their outputs, by default.
+	// Default is pack type "tar", with repeatr's default filters.
+	Output OutputConfig
+
+	// Overrides of Output for the operations in specific modules.
+	// Keys are module names; within each, keys are output names, as
+	// "step.slot" (prefixed by the path of submodules containing the step,
+	// if any), or "*" for all the outputs of the module.
+	// More specific settings take precedence, field by field.
+	ModuleOutputs map[api.ModuleName]map[string]OutputConfig
+
+	// Paths of local warehouses to store produced wares of pack types
+	// other than tar, keyed by pack type.  (Tar wares always go in
+	// StagingWarehouse.)  Outputs can only use pack types listed here.
+	StagingWarehouses map[api.PackType]string
+}
+
+var Config_AtlasEntry = atlas.BuildEntry(Config{}).StructMap().
+	SetKeyValue("stagingWarehouse", "StagingWarehouse").
+	SetKeyValue("memoDir", "MemoDir").
+	SetKeyValue("catalogRoots", "CatalogRoots").
+	SetKeyValue("modules", "Modules").
+	SetKeyValue("rootModule", "RootModule").
+	SetKeyValue("saga", "Saga").
+	SetKeyValue("replace", "Replace").
+	SetKeyValue("timeout", "Timeout").
+	SetKeyValue("stepTimeout", "StepTimeout").
+	SetKeyValue("output", "Output").
+	SetKeyValue("moduleOutputs", "ModuleOutputs").
+	SetKeyValue("stagingWarehouses", "StagingWarehouses").
+	Complete()
+
+var Atlas_Config = atlas.MustBuild(
+	Config_AtlasEntry,
+	OutputConfig_AtlasEntry,
+)
+
+// LoadConfig reads and parses the workspace config file.
+//
+// If the config file does not exist (or is empty), a zero Config is returned
+// and there is no error: workspaces are not required to have any config.
+func LoadConfig(lm layout.Workspace) (*Config, error) {
+	pth := lm.WorkspaceConfigFile()
+	f, err := os.Open(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, errcat.Errorf(ConfigLoadError, "cannot open workspace config: %s", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errcat.Errorf(ConfigLoadError, "cannot open workspace config: %s", err)
+	}
+	if fi.IsDir() {
+		return nil, errcat.Errorf(ConfigLoadError, "workspace config %q must be a file", pth)
+	}
+	if fi.Size() == 0 {
+		return &Config{}, nil
+	}
+	var cfg Config
+	if err := refmt.NewUnmarshallerAtlased(json.DecodeOptions{}, f, Atlas_Config).Unmarshal(&cfg); err != nil {
+		return nil, errcat.ErrorDetailed(
+			ConfigLoadError,
+			fmt.Sprintf("workspace config %q failed to parse: %s", pth, err),
+			map[string]string{
+				"path": pth,
+			})
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,203 @@
+package workspace
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/warpfork/go-errcat"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/go-timeless-api/hitch"
+)
+
+/*
+	Module mapping config is a map of path patterns to module names.
+
+	There are two kinds of pattern:
+
+	- A pattern ending in "*" is a *prefix* pattern.  It matches every module
+	found beneath the dir before the "*" (at any depth), and the value is a
+	prefix for the module name.  The path of the module beneath that dir is
+	appended to the prefix to make the full module name.
+	e.g. `"modules/*": "foo.example.org/"` means a module at "modules/bar/baz"
+	is named "foo.example.org/bar/baz".
+
+	- Any other pattern is an *exact* pattern.  It matches only the module at
+	exactly that path, and the value is the complete module name.
+	e.g. `"tools/frobnicator": "example.org/frob"`.
+
+	Wildcards are only allowed as the final path segment of a pattern,
+	so that every mapping can be reversed (from name back to path).
+
+	When several patterns match, the most specific wins: exact patterns beat
+	prefix patterns; among prefix patterns, the one with the longest dir wins
+	when mapping paths to names, and the one with the longest name prefix wins
+	when mapping names to paths.  A workspace where two patterns would give
+	one name to two different paths is a conflict, and is rejected.
+
+	If no module mapping is configured at all, the default is `"*": ""`:
+	every dir is its own fully-qualified module name.
+*/
+
+var defaultModuleMapping = map[string]string{"*": ""}
+
+type modulePattern struct {
+	pattern string // The pattern as written in the config.
+	dir     string // Path within the workspace.  Empty string for the workspace root.
+	prefix  bool   // True if the pattern matches everything beneath dir; false if it matches only dir.
+	name    string // The module name (if exact) or module name prefix (if prefix).
+}
+
+// matchPath returns the module name for a path if this pattern matches it.
+func (p modulePattern) matchPath(modPath string) (api.ModuleName, bool) {
+	if !p.prefix {
+		return api.ModuleName(p.name), modPath == p.dir
+	}
+	switch {
+	case p.dir == "":
+		if modPath == "" {
+			return "", false
+		}
+		return api.ModuleName(p.name + modPath), true
+	case strings.HasPrefix(modPath, p.dir+"/"):
+		return api.ModuleName(p.name + modPath[len(p.dir)+1:]), true
+	default:
+		return "", false
+	}
+}
+
+// matchName returns the path for a module name if this pattern could have produced it.
+func (p modulePattern) matchName(modName api.ModuleName) (string, bool) {
+	if !p.prefix {
+		return p.dir, string(modName) == p.name
+	}
+	if !strings.HasPrefix(string(modName), p.name) || len(modName) == len(p.name) {
+		return "", false
+	}
+	return path.Join(p.dir, string(modName)[len(p.name):]), true
+}
+
+// parseModuleMapping validates the module mapping config and returns it
+// as a list of patterns, sorted by pattern string for determinism.
+func parseModuleMapping(cfg map[string]string) ([]modulePattern, error) {
+	if len(cfg) == 0 {
+		cfg = defaultModuleMapping
+	}
+	patterns := make([]modulePattern, 0, len(cfg))
+	for pattern, name := range cfg {
+		p, err := parseModulePattern(pattern, name)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	sort.Slice(patterns, func(i, j int) bool { return patterns[i].pattern < patterns[j].pattern })
+
+	// Look for any two patterns which would claim the same names.
+	//  Two exact patterns with the same name are a conflict;
+	//  two prefix patterns with the same name prefix are a conflict.
+	//  (An exact pattern shadowing part of a prefix pattern's namespace is
+	//   not a conflict here; that's caught per-module at resolve time.)
+	for i, a := range patterns {
+		for _, b := range patterns[i+1:] {
+			if a.prefix == b.prefix && a.name == b.name {
+				return nil, errcat.ErrorDetailed(
+					hitch.ErrUsage,
+					fmt.Sprintf("workspace module mapping is ambiguous: patterns %q and %q both map to module name %q", a.pattern, b.pattern, a.name),
+					map[string]string{
+						"ref": a.name,
+					})
+			}
+		}
+	}
+	return patterns, nil
+}
+
+func parseModulePattern(pattern, name string) (modulePattern, error) {
+	fail := func(reason string) (modulePattern, error) {
+		return modulePattern{}, errcat.ErrorDetailed(
+			hitch.ErrUsage,
+			fmt.Sprintf("invalid workspace module mapping %q: %s", pattern, reason),
+			map[string]string{
+				"pattern": pattern,
+			})
+	}
+	p := modulePattern{pattern: pattern, name: name}
+	pth := path.Clean(pattern)
+	if path.IsAbs(pth) || pth == ".." || strings.HasPrefix(pth, "../") {
+		return fail("patterns must be relative paths within the workspace")
+	}
+	if pth == "*" {
+		p.prefix = true
+	} else if strings.HasSuffix(pth, "/*") {
+		p.prefix = true
+		pth = pth[:len(pth)-2]
+	}
+	if strings.ContainsAny(pth, "*?[") {
+		return fail("wildcards are only supported as the final path segment, and only as \"*\"")
+	}
+	switch {
+	case p.prefix && pth == "*":
+		p.dir = ""
+	case pth == ".":
+		return fail("the workspace root cannot be mapped here; use the \"rootModule\" config instead")
+	default:
+		p.dir = pth
+	}
+	if !p.prefix {
+		if err := api.ModuleName(name).Validate(); err != nil {
+			return fail(fmt.Sprintf("%q is not a valid module name: %s", name, err))
+		}
+	}
+	return p, nil
+}
+
+// moduleNameForPath maps a module's path within the workspace to its name.
+// Returns false if no pattern matches.
+func moduleNameForPath(patterns []modulePattern, modPath string) (api.ModuleName, bool) {
+	var best *modulePattern
+	var bestName api.ModuleName
+	for i, p := range patterns {
+		name, ok := p.matchPath(modPath)
+		if !ok {
+			continue
+		}
+		if best == nil || morePathSpecific(p, *best) {
+			best, bestName = &patterns[i], name
+		}
+	}
+	return bestName, best != nil
+}
+
+// modulePathForName maps a module name to its path within the workspace.
+// Returns false if no pattern matches.
+func modulePathForName(patterns []modulePattern, modName api.ModuleName) (string, bool) {
+	var best *modulePattern
+	var bestPath string
+	for i, p := range patterns {
+		pth, ok := p.matchName(modName)
+		if !ok {
+			continue
+		}
+		if best == nil || moreNameSpecific(p, *best) {
+			best, bestPath = &patterns[i], pth
+		}
+	}
+	return bestPath, best != nil
+}
+
+func morePathSpecific(a, b modulePattern) bool {
+	if a.prefix != b.prefix {
+		return !a.prefix
+	}
+	return len(a.dir) > len(b.dir)
+}
+
+func moreNameSpecific(a, b modulePattern) bool {
+	if a.prefix != b.prefix {
+		return !a.prefix
+	}
+	return len(a.name) > len(b.name)
+}
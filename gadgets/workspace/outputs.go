@@ -0,0 +1,200 @@
+package workspace
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polydawn/refmt/obj/atlas"
+	"github.com/warpfork/go-errcat"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/reach/gadgets/operation"
+)
+
+// OutputConfig says how operation outputs are packed:
+// what pack type to use, and how to normalize file metadata while packing.
+//
+// Every field is optional; an empty field means "whatever the less specific
+// config says", and ultimately, pack type "tar" and repeatr's default filters.
+//
+// Filter values are as repeatr takes them:
+// Uid and Gid are "keep" or a number;
+// Mtime is "keep", "@" and a unix timestamp, or an RFC3339 date;
+// Sticky is "keep" or "zero".
+type OutputConfig struct {
+	PackType api.PackType
+	Uid      string
+	Gid      string
+	Mtime    string
+	Sticky   string
+}
+
+var OutputConfig_AtlasEntry = atlas.BuildEntry(OutputConfig{}).StructMap().
+	SetKeyValue("packType", "PackType").
+	SetKeyValue("uid", "Uid").
+	SetKeyValue("gid", "Gid").
+	SetKeyValue("mtime", "Mtime").
+	SetKeyValue("sticky", "Sticky").
+	Complete()
+
+// over returns the config with any empty fields filled in from base.
+func (oc OutputConfig) over(base OutputConfig) OutputConfig {
+	pick := func(s, base string) string {
+		if s == "" {
+			return base
+		}
+		return s
+	}
+	return OutputConfig{
+		PackType: api.PackType(pick(string(oc.PackType), string(base.PackType))),
+		Uid:      pick(oc.Uid, base.Uid),
+		Gid:      pick(oc.Gid, base.Gid),
+		Mtime:    pick(oc.Mtime, base.Mtime),
+		Sticky:   pick(oc.Sticky, base.Sticky),
+	}
+}
+
+// spec converts the config to the form formulas use.
+func (oc OutputConfig) spec() api.FormulaOutputSpec {
+	spec := operation.DefaultOutputSpec
+	if oc.PackType != "" {
+		spec.PackType = oc.PackType
+	}
+	spec.Filter = api.FilesetFilters{
+		Uid:    oc.Uid,
+		Gid:    oc.Gid,
+		Mtime:  oc.Mtime,
+		Sticky: oc.Sticky,
+	}
+	return spec
+}
+
+func (oc OutputConfig) validate() error {
+	for _, id := range []struct{ name, value string }{{"uid", oc.Uid}, {"gid", oc.Gid}} {
+		if id.value == "" || id.value == "keep" {
+			continue
+		}
+		if n, err := strconv.Atoi(id.value); err != nil || n < 0 {
+			return fmt.Errorf("%s filter %q should be \"keep\" or a number", id.name, id.value)
+		}
+	}
+	switch {
+	case oc.Mtime == "", oc.Mtime == "keep":
+	case strings.HasPrefix(oc.Mtime, "@"):
+		if _, err := strconv.ParseInt(oc.Mtime[1:], 10, 64); err != nil {
+			return fmt.Errorf("mtime filter %q should be \"keep\", \"@\" and a unix timestamp, or an RFC3339 date", oc.Mtime)
+		}
+	default:
+		if _, err := time.Parse(time.RFC3339, oc.Mtime); err != nil {
+			return fmt.Errorf("mtime filter %q should be \"keep\", \"@\" and a unix timestamp, or an RFC3339 date", oc.Mtime)
+		}
+	}
+	switch oc.Sticky {
+	case "", "keep", "zero":
+	default:
+		return fmt.Errorf("sticky filter %q should be \"keep\" or \"zero\"", oc.Sticky)
+	}
+	return nil
+}
+
+// validateOutputs checks all the output config, and that there's a staging
+// warehouse for every pack type it uses.
+func validateOutputs(cfg Config) error {
+	check := func(where string, oc OutputConfig) error {
+		if err := oc.validate(); err != nil {
+			return errcat.Errorf(ConfigLoadError, "workspace config %s: %s", where, err)
+		}
+		if oc.PackType != "" && oc.PackType != operation.DefaultOutputSpec.PackType {
+			if _, ok := cfg.StagingWarehouses[oc.PackType]; !ok {
+				return errcat.Errorf(ConfigLoadError, "workspace config %s: there's no staging warehouse for pack type %q (configure one in stagingWarehouses)", where, oc.PackType)
+			}
+		}
+		return nil
+	}
+	if _, ok := cfg.StagingWarehouses[operation.DefaultOutputSpec.PackType]; ok {
+		return errcat.Errorf(ConfigLoadError, "workspace config stagingWarehouses: tar wares always go in the stagingWarehouse")
+	}
+	if err := check("output", cfg.Output); err != nil {
+		return err
+	}
+	for modName, outputs := range cfg.ModuleOutputs {
+		if err := modName.Validate(); err != nil {
+			return errcat.Errorf(ConfigLoadError, "workspace config moduleOutputs: %q is not a valid module name: %s", modName, err)
+		}
+		for output, oc := range outputs {
+			if err := check(fmt.Sprintf("moduleOutputs for %q, output %q", modName, output), oc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WareStaging returns where produced wares should be stored, per pack type:
+// the staging warehouse for tar, and whatever else the config adds.
+func (ws Workspace) WareStaging() api.WareStaging {
+	wareStaging := api.WareStaging{ByPackType: map[api.PackType]api.WarehouseLocation{
+		"tar": ws.StagingWarehouseLoc(),
+	}}
+	for packType, pth := range ws.Config.StagingWarehouses {
+		wareStaging.ByPackType[packType] = api.WarehouseLocation("ca+file://" + ws.resolvePath(pth))
+	}
+	return wareStaging
+}
+
+// StagingWarehousePaths returns the filesystem paths of all the staging
+// warehouses (see WareStaging), so they can be made before use.
+func (ws Workspace) StagingWarehousePaths() []string {
+	others := []string{}
+	for _, pth := range ws.Config.StagingWarehouses {
+		others = append(others, ws.resolvePath(pth))
+	}
+	sort.Strings(others)
+	return append([]string{ws.StagingWarehousePath()}, others...)
+}
+
+// StagingWareSourcing returns the staging warehouses as a WareSourcing,
+// so that wares produced earlier can be found again.
+func (ws Workspace) StagingWareSourcing() api.WareSourcing {
+	wareSourcing := api.WareSourcing{}
+	wareStaging := ws.WareStaging()
+	packTypes := make([]string, 0, len(wareStaging.ByPackType))
+	for packType := range wareStaging.ByPackType {
+		packTypes = append(packTypes, string(packType))
+	}
+	sort.Strings(packTypes)
+	for _, packType := range packTypes {
+		wareSourcing.AppendByPackType(api.PackType(packType), wareStaging.ByPackType[api.PackType(packType)])
+	}
+	return wareSourcing
+}
+
+// OutputOverrides returns the names of outputs of a module which the config
+// has specific settings for, so they can be checked against the module.
+// Names are as in Config.ModuleOutputs, minus the "*" entry.
+func (ws Workspace) OutputOverrides(modName api.ModuleName) []string {
+	outputs := []string{}
+	for output := range ws.Config.ModuleOutputs[modName] {
+		if output != "*" {
+			outputs = append(outputs, output)
+		}
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+// OutputSpec returns how to pack an output of an operation in a module
+// (the step ref being fully contextualized within the module):
+// the workspace default, overridden by the config for the module,
+// overridden in turn by the config for that specific output.
+func (ws Workspace) OutputSpec(modName api.ModuleName, step api.SubmoduleStepRef, slot api.SlotName) api.FormulaOutputSpec {
+	oc := ws.Config.Output
+	if outputs, ok := ws.Config.ModuleOutputs[modName]; ok {
+		oc = outputs["*"].over(oc)
+		oc = outputs[step.String()+"."+string(slot)].over(oc)
+	}
+	return oc.spec()
+}
@@ -3,13 +3,18 @@ package workspace
 import (
 	"fmt"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/warpfork/go-errcat"
 
 	"go.polydawn.net/go-timeless-api"
 	"go.polydawn.net/go-timeless-api/funcs"
 	"go.polydawn.net/go-timeless-api/hitch"
+	"go.polydawn.net/reach/gadgets/catalog"
+	hitchGadget "go.polydawn.net/reach/gadgets/catalog/hitch"
 	"go.polydawn.net/reach/gadgets/layout"
 )
 
@@ -26,14 +31,179 @@ const anonymousModuleName api.ModuleName = api.ModuleName("unnamed")
 type Workspace struct {
 	Layout layout.Workspace
 
-	// Future: should have some config for which paths inside the workspace
-	//  are considered to contain modules.
-	//  (And for bonus points, any name transform patterns.)
-	//  This should work with globs, because otherwise scaling will be irritating.
-	//  e.g. `"*": ""` if every dir is a fully-qualified module name.
-	//  e.g. `"modules/*": "foo.example.org/"` if every dir under "modules/"
-	//   should be treated as a module name prefixed with "foo.example.org/".
-	// Right now we're pretending everything is `"*": ""` plus `".": "unnamed"`.
+	// Config as loaded from the workspace config file.
+	// May be zero, in which case all the defaults apply.
+	//
+	// Config.Modules says which paths inside the workspace are considered
+	// to contain modules, and what names they have.
+	// See the docs in moduleMapping.go for how the patterns work.
+	// The workspace root itself is named by Config.RootModule instead
+	// (or "unnamed", if that's not set).
+	Config Config
+}
+
+// Load parses the config of the workspace at the given layout
+// and returns a Workspace ready for use.
+func Load(lm layout.Workspace) (*Workspace, error) {
+	cfg, err := LoadConfig(lm)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseModuleMapping(cfg.Modules); err != nil {
+		return nil, err
+	}
+	if cfg.RootModule != "" {
+		if err := cfg.RootModule.Validate(); err != nil {
+			return nil, errcat.ErrorDetailed(
+				hitch.ErrUsage,
+				fmt.Sprintf("workspace config rootModule %q is not a valid module name: %s", cfg.RootModule, err),
+				map[string]string{
+					"ref": string(cfg.RootModule),
+				})
+		}
+	}
+	if cfg.Saga != "" {
+		if _, err := catalog.ParseSagaName(cfg.Saga); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := parseReplacements(cfg.Replace); err != nil {
+		return nil, err
+	}
+	for key, value := range map[string]string{"timeout": cfg.Timeout, "stepTimeout": cfg.StepTimeout} {
+		if _, err := parseTimeout(value); err != nil {
+			return nil, errcat.ErrorDetailed(
+				ConfigLoadError,
+				fmt.Sprintf("workspace config %s %q is not a valid duration: %s", key, value, err),
+				map[string]string{
+					"path": lm.WorkspaceConfigFile(),
+				})
+		}
+	}
+	if err := validateOutputs(*cfg); err != nil {
+		return nil, err
+	}
+	return &Workspace{
+		Layout: lm,
+		Config: *cfg,
+	}, nil
+}
+
+// StagingWarehousePath returns the filesystem path of the workspace's
+// local warehouse, where produced wares are stored.
+func (ws Workspace) StagingWarehousePath() string {
+	if ws.Config.StagingWarehouse == "" {
+		return ws.Layout.StagingWarehousePath()
+	}
+	return ws.resolvePath(ws.Config.StagingWarehouse)
+}
+
+// StagingWarehouseLoc returns StagingWarehousePath as a WarehouseLocation.
+func (ws Workspace) StagingWarehouseLoc() api.WarehouseLocation {
+	return api.WarehouseLocation("ca+file://" + ws.StagingWarehousePath())
+}
+
+// MemoDir returns the filesystem path of the dir repeatr should use for
+// memoizing formula results.
+func (ws Workspace) MemoDir() string {
+	if ws.Config.MemoDir == "" {
+		return ws.Layout.MemoDir()
+	}
+	return ws.resolvePath(ws.Config.MemoDir)
+}
+
+// CatalogTrees returns every catalog tree the workspace reads from.
+// The workspace's own catalog is always first; any additional roots
+// from the config follow, in the order they were configured.
+func (ws Workspace) CatalogTrees() []catalog.Tree {
+	trees := []catalog.Tree{{ws.Layout.CatalogRoot()}}
+	for _, pth := range ws.Config.CatalogRoots {
+		trees = append(trees, catalog.Tree{ws.resolvePath(pth)})
+	}
+	return trees
+}
+
+// RootModuleName returns the name of the module in the workspace root dir.
+// This is "unnamed" unless the workspace config says otherwise.
+func (ws Workspace) RootModuleName() api.ModuleName {
+	if ws.Config.RootModule == "" {
+		return anonymousModuleName
+	}
+	return ws.Config.RootModule
+}
+
+// Timeout returns the time limit for evaluating a whole module,
+// or zero if there's no limit.
+func (ws Workspace) Timeout() time.Duration {
+	d, _ := parseTimeout(ws.Config.Timeout)
+	return d
+}
+
+// StepTimeout returns the time limit for each operation in a module,
+// or zero if there's no limit.
+func (ws Workspace) StepTimeout() time.Duration {
+	d, _ := parseTimeout(ws.Config.StepTimeout)
+	return d
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("cannot be negative")
+	}
+	return d, nil
+}
+
+// Replacements returns the catalog import replacements from the config,
+// in a stable order.
+func (ws Workspace) Replacements() []hitchGadget.Replacement {
+	replacements, err := parseReplacements(ws.Config.Replace)
+	if err != nil {
+		panic(fmt.Errorf("workspace config should have been validated by workspace.Load: %s", err))
+	}
+	return replacements
+}
+
+func parseReplacements(cfg map[string]string) ([]hitchGadget.Replacement, error) {
+	froms := make([]string, 0, len(cfg))
+	for from := range cfg {
+		froms = append(froms, from)
+	}
+	sort.Strings(froms)
+	replacements := make([]hitchGadget.Replacement, 0, len(cfg))
+	for _, from := range froms {
+		r, err := hitchGadget.ParseReplacement(from, cfg[from])
+		if err != nil {
+			return nil, err
+		}
+		replacements = append(replacements, *r)
+	}
+	return replacements, nil
+}
+
+// modulePatterns returns the parsed module mapping config.
+// The config must already have been validated (workspace.Load does this).
+func (ws Workspace) modulePatterns() []modulePattern {
+	patterns, err := parseModuleMapping(ws.Config.Modules)
+	if err != nil {
+		panic(fmt.Errorf("workspace config should have been validated by workspace.Load: %s", err))
+	}
+	return patterns
+}
+
+// resolvePath interprets a path from the config: absolute paths are
+// returned unchanged, and relative paths are joined to the workspace root.
+func (ws Workspace) resolvePath(pth string) string {
+	if filepath.IsAbs(pth) {
+		return filepath.Clean(pth)
+	}
+	return filepath.Join(ws.Layout.WorkspaceRoot(), pth)
 }
 
 // ResolveModuleName returns the public name of a module based on its path
@@ -43,7 +213,11 @@ type Workspace struct {
 // the workspace configuration can specify alternate mappings.
 // (As a special case, if the module path is the exact same as the workspace
 // root, then it will be mapped to the module name "unnamed" -- this may also
-// be overriden by workspace config.)
+// be overriden by workspace config; see RootModuleName.)
+//
+// If the workspace config does not map the path to any module name, or if
+// the name it maps to is also claimed by a module at a different path,
+// an error is returned.
 //
 // The moduleLayout argument must be for a path inside the workspace;
 // otherwise a panic will be raised.
@@ -56,15 +230,23 @@ func (ws Workspace) ResolveModuleName(moduleLayout layout.Module) (api.ModuleNam
 		panic("moduleRoot must be within workspaceRoot")
 	}
 
-	// If the module is in the workspace root, it's definitely anon.
+	// If the module is in the workspace root, it's the root module.
 	if len(modRoot) == len(wsRoot) {
-		return anonymousModuleName, nil
+		return ws.RootModuleName(), nil
 	}
 
-	// Future: check for config that remaps paths<->names.
-
-	// Munge path into name.
-	modName := api.ModuleName(modRoot[len(wsRoot)+1:])
+	// Check the config that maps paths<->names.
+	modPath := modRoot[len(wsRoot)+1:]
+	patterns := ws.modulePatterns()
+	modName, ok := moduleNameForPath(patterns, modPath)
+	if !ok {
+		return "", errcat.ErrorDetailed(
+			hitch.ErrUsage,
+			fmt.Sprintf("%q is not mapped to a module name by the workspace config", modPath),
+			map[string]string{
+				"path": modPath,
+			})
+	}
 
 	// Sanity check name.
 	if err := modName.Validate(); err != nil {
@@ -75,6 +257,26 @@ func (ws Workspace) ResolveModuleName(moduleLayout layout.Module) (api.ModuleNam
 				"ref": string(modName),
 			})
 	}
+
+	// Check the mapping round-trips.  If it doesn't, some other path claims the same name.
+	if modName == ws.RootModuleName() {
+		return "", errcat.ErrorDetailed(
+			hitch.ErrUsage,
+			fmt.Sprintf("workspace module mapping conflict: %q maps to module name %q, but that is the name of the workspace root module", modPath, modName),
+			map[string]string{
+				"ref":  string(modName),
+				"path": modPath,
+			})
+	}
+	if revPath, _ := modulePathForName(patterns, modName); revPath != modPath {
+		return "", errcat.ErrorDetailed(
+			hitch.ErrUsage,
+			fmt.Sprintf("workspace module mapping conflict: %q maps to module name %q, but that name maps to %q", modPath, modName, revPath),
+			map[string]string{
+				"ref":  string(modName),
+				"path": modPath,
+			})
+	}
 	return modName, nil
 }
 
@@ -83,7 +285,6 @@ func (ws Workspace) ResolveModuleName(moduleLayout layout.Module) (api.ModuleNam
 //
 // The module name must be matched by some part of the workspace's
 // module mapping config, or nil will be returned.
-// (Note: this feature is NYI, so nil will currently never be returned.)
 //
 // Providing an invalid ModuleName will panic.
 //
@@ -94,20 +295,25 @@ func (ws Workspace) GetModuleLayout(modName api.ModuleName) *layout.Module {
 	// Sanity check modName.
 	funcs.MustValidate(modName)
 
-	// Anonymous modules point to the workspace root
-	if modName == anonymousModuleName {
+	// The root module (anonymous, unless configured otherwise) points to the workspace root.
+	if modName == ws.RootModuleName() {
 		modLayout := layout.NewModule(
 			ws.Layout,
 			ws.Layout.WorkspaceRoot(),
 		)
 		return &modLayout
 	}
-	// Future: check for config that remaps paths<->names.
+
+	// Check the config that maps paths<->names.
+	modPath, ok := modulePathForName(ws.modulePatterns(), modName)
+	if !ok {
+		return nil
+	}
 
 	// Assemble and return the module layout description struct.
 	modLayout := layout.NewModule(
 		ws.Layout,
-		path.Join(ws.Layout.WorkspaceRoot(), string(modName)),
+		path.Join(ws.Layout.WorkspaceRoot(), modPath),
 	)
 	return &modLayout
 }
@@ -0,0 +1,225 @@
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/warpfork/go-wish"
+
+	"go.polydawn.net/go-timeless-api"
+	"go.polydawn.net/reach/gadgets/catalog"
+	"go.polydawn.net/reach/gadgets/layout"
+)
+
+func withWorkspace(t *testing.T, config string, fn func(ws *Workspace)) {
+	tmpdir, err := ioutil.TempDir("", "reach-test-workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if err := os.Mkdir(filepath.Join(tmpdir, ".timeless"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if config != "" {
+		if err := ioutil.WriteFile(filepath.Join(tmpdir, ".timeless", "workspace.tl"), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lm, err := layout.FindWorkspace(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := Load(*lm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(ws)
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("absent config should give defaults", func(t *testing.T) {
+		withWorkspace(t, "", func(ws *Workspace) {
+			Wish(t, ws.StagingWarehousePath(), ShouldEqual, ws.Layout.StagingWarehousePath())
+			Wish(t, ws.MemoDir(), ShouldEqual, ws.Layout.MemoDir())
+			Wish(t, ws.CatalogTrees(), ShouldEqual, []catalog.Tree{{ws.Layout.CatalogRoot()}})
+		})
+	})
+	t.Run("configured paths should resolve against the workspace root", func(t *testing.T) {
+		withWorkspace(t, `{
+			"stagingWarehouse": "store/wares",
+			"memoDir": "/var/memo",
+			"catalogRoots": ["vendor/catalog"]
+		}`, func(ws *Workspace) {
+			root := ws.Layout.WorkspaceRoot()
+			Wish(t, ws.StagingWarehousePath(), ShouldEqual, filepath.Join(root, "store/wares"))
+			Wish(t, string(ws.StagingWarehouseLoc()), ShouldEqual, "ca+file://"+filepath.Join(root, "store/wares"))
+			Wish(t, ws.MemoDir(), ShouldEqual, "/var/memo")
+			Wish(t, ws.CatalogTrees(), ShouldEqual, []catalog.Tree{
+				{ws.Layout.CatalogRoot()},
+				{filepath.Join(root, "vendor/catalog")},
+			})
+		})
+	})
+	t.Run("unparsable config should error", func(t *testing.T) {
+		tmpdir, err := ioutil.TempDir("", "reach-test-workspace")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpdir)
+		os.Mkdir(filepath.Join(tmpdir, ".timeless"), 0755)
+		ioutil.WriteFile(filepath.Join(tmpdir, ".timeless", "workspace.tl"), []byte(`{"memoDir":`), 0644)
+		lm, _ := layout.FindWorkspace(tmpdir)
+		_, err = Load(*lm)
+		Wish(t, err != nil, ShouldEqual, true)
+	})
+	t.Run("timeouts should parse as durations", func(t *testing.T) {
+		withWorkspace(t, "", func(ws *Workspace) {
+			Wish(t, ws.Timeout(), ShouldEqual, time.Duration(0))
+			Wish(t, ws.StepTimeout(), ShouldEqual, time.Duration(0))
+		})
+		withWorkspace(t, `{"timeout": "2h", "stepTimeout": "90s"}`, func(ws *Workspace) {
+			Wish(t, ws.Timeout(), ShouldEqual, 2*time.Hour)
+			Wish(t, ws.StepTimeout(), ShouldEqual, 90*time.Second)
+		})
+	})
+}
+
+func TestModuleMapping(t *testing.T) {
+	t.Run("default mapping treats paths as names", func(t *testing.T) {
+		withWorkspace(t, "", func(ws *Workspace) {
+			root := ws.Layout.WorkspaceRoot()
+			modName, err := ws.ResolveModuleName(layout.NewModule(ws.Layout, filepath.Join(root, "example.org/proj-foo")))
+			Wish(t, err, ShouldEqual, nil)
+			Wish(t, modName, ShouldEqual, api.ModuleName("example.org/proj-foo"))
+			Wish(t, ws.GetModuleLayout("example.org/proj-foo").ModuleRoot(), ShouldEqual, filepath.Join(root, "example.org/proj-foo"))
+			modName, err = ws.ResolveModuleName(layout.NewModule(ws.Layout, root))
+			Wish(t, err, ShouldEqual, nil)
+			Wish(t, modName, ShouldEqual, api.ModuleName("unnamed"))
+		})
+	})
+	withWorkspace(t, `{
+		"modules": {
+			"*": "",
+			"modules/*": "example.com/",
+			"tools/frob": "example.org/frob"
+		}
+	}`, func(ws *Workspace) {
+		root := ws.Layout.WorkspaceRoot()
+		resolve := func(pth string) (api.ModuleName, error) {
+			return ws.ResolveModuleName(layout.NewModule(ws.Layout, filepath.Join(root, pth)))
+		}
+		t.Run("prefix patterns map both directions", func(t *testing.T) {
+			modName, err := resolve("modules/foo/bar")
+			Wish(t, err, ShouldEqual, nil)
+			Wish(t, modName, ShouldEqual, api.ModuleName("example.com/foo/bar"))
+			Wish(t, ws.GetModuleLayout("example.com/foo/bar").ModuleRoot(), ShouldEqual, filepath.Join(root, "modules/foo/bar"))
+		})
+		t.Run("exact patterns map both directions", func(t *testing.T) {
+			modName, err := resolve("tools/frob")
+			Wish(t, err, ShouldEqual, nil)
+			Wish(t, modName, ShouldEqual, api.ModuleName("example.org/frob"))
+			Wish(t, ws.GetModuleLayout("example.org/frob").ModuleRoot(), ShouldEqual, filepath.Join(root, "tools/frob"))
+		})
+		t.Run("less specific patterns still apply elsewhere", func(t *testing.T) {
+			modName, err := resolve("example.net/thing")
+			Wish(t, err, ShouldEqual, nil)
+			Wish(t, modName, ShouldEqual, api.ModuleName("example.net/thing"))
+		})
+		t.Run("paths whose name is claimed by another path are a conflict", func(t *testing.T) {
+			_, err := resolve("example.com/foo")
+			Wish(t, err != nil, ShouldEqual, true)
+		})
+	})
+	t.Run("unmapped paths and names are rejected", func(t *testing.T) {
+		withWorkspace(t, `{"modules": {"modules/*": "example.com/"}}`, func(ws *Workspace) {
+			_, err := ws.ResolveModuleName(layout.NewModule(ws.Layout, filepath.Join(ws.Layout.WorkspaceRoot(), "elsewhere/foo")))
+			Wish(t, err != nil, ShouldEqual, true)
+			Wish(t, ws.GetModuleLayout("example.org/foo") == nil, ShouldEqual, true)
+		})
+	})
+	t.Run("invalid mappings are rejected at load", func(t *testing.T) {
+		for _, cfg := range []map[string]string{
+			{"a/*": "example.com/", "b/*": "example.com/"},
+			{"a": "example.com/x", "b": "example.com/x"},
+			{"a/*/b": "example.com/"},
+			{"../a/*": "example.com/"},
+			{"a": "not a valid name!"},
+		} {
+			_, err := parseModuleMapping(cfg)
+			Wish(t, err != nil, ShouldEqual, true)
+		}
+	})
+}
+
+func TestRootModuleName(t *testing.T) {
+	withWorkspace(t, `{"rootModule": "example.com/tool"}`, func(ws *Workspace) {
+		root := ws.Layout.WorkspaceRoot()
+		t.Run("root module takes the configured name", func(t *testing.T) {
+			modName, err := ws.ResolveModuleName(layout.NewModule(ws.Layout, root))
+			Wish(t, err, ShouldEqual, nil)
+			Wish(t, modName, ShouldEqual, api.ModuleName("example.com/tool"))
+			Wish(t, ws.GetModuleLayout("example.com/tool").ModuleRoot(), ShouldEqual, root)
+		})
+		t.Run("other paths may not claim the root module name", func(t *testing.T) {
+			_, err := ws.ResolveModuleName(layout.NewModule(ws.Layout, filepath.Join(root, "example.com/tool")))
+			Wish(t, err != nil, ShouldEqual, true)
+		})
+		t.Run("\"unnamed\" is no longer the root", func(t *testing.T) {
+			Wish(t, ws.GetModuleLayout("unnamed").ModuleRoot(), ShouldEqual, filepath.Join(root, "unnamed"))
+		})
+	})
+}
+
+func TestOutputConfig(t *testing.T) {
+	t.Run("absent config should pack tar with default filters", func(t *testing.T) {
+		withWorkspace(t, "", func(ws *Workspace) {
+			Wish(t, ws.OutputSpec("foo", api.SubmoduleStepRef{"", "build"}, "out"), ShouldEqual, api.FormulaOutputSpec{PackType: "tar"})
+			Wish(t, ws.WareStaging(), ShouldEqual, api.WareStaging{ByPackType: map[api.PackType]api.WarehouseLocation{"tar": ws.StagingWarehouseLoc()}})
+		})
+	})
+	withWorkspace(t, `{
+		"output": {"uid": "1000", "gid": "1000", "mtime": "@1262304000"},
+		"moduleOutputs": {
+			"example.com/foo": {
+				"*": {"uid": "keep"},
+				"sub.build.out": {"packType": "zip", "sticky": "zero"}
+			}
+		},
+		"stagingWarehouses": {"zip": "store/zips"}
+	}`, func(ws *Workspace) {
+		t.Run("more specific settings take precedence field by field", func(t *testing.T) {
+			Wish(t, ws.OutputSpec("example.com/bar", api.SubmoduleStepRef{"", "build"}, "out"), ShouldEqual, api.FormulaOutputSpec{
+				PackType: "tar",
+				Filter:   api.FilesetFilters{Uid: "1000", Gid: "1000", Mtime: "@1262304000"},
+			})
+			Wish(t, ws.OutputSpec("example.com/foo", api.SubmoduleStepRef{"", "build"}, "out"), ShouldEqual, api.FormulaOutputSpec{
+				PackType: "tar",
+				Filter:   api.FilesetFilters{Uid: "keep", Gid: "1000", Mtime: "@1262304000"},
+			})
+			Wish(t, ws.OutputSpec("example.com/foo", api.SubmoduleStepRef{"sub", "build"}, "out"), ShouldEqual, api.FormulaOutputSpec{
+				PackType: "zip",
+				Filter:   api.FilesetFilters{Uid: "keep", Gid: "1000", Mtime: "@1262304000", Sticky: "zero"},
+			})
+			Wish(t, ws.OutputOverrides("example.com/foo"), ShouldEqual, []string{"sub.build.out"})
+		})
+		t.Run("each pack type should have a staging warehouse", func(t *testing.T) {
+			Wish(t, string(ws.WareStaging().ByPackType["zip"]), ShouldEqual, "ca+file://"+filepath.Join(ws.Layout.WorkspaceRoot(), "store/zips"))
+			Wish(t, ws.StagingWarehousePaths(), ShouldEqual, []string{ws.StagingWarehousePath(), filepath.Join(ws.Layout.WorkspaceRoot(), "store/zips")})
+		})
+	})
+	t.Run("invalid output config is rejected at load", func(t *testing.T) {
+		for _, cfg := range []Config{
+			{Output: OutputConfig{PackType: "zip"}},
+			{Output: OutputConfig{Uid: "root"}},
+			{Output: OutputConfig{Mtime: "yesterday"}},
+			{Output: OutputConfig{Sticky: "maybe"}},
+			{ModuleOutputs: map[api.ModuleName]map[string]OutputConfig{"foo": {"*": {Gid: "-1"}}}},
+			{StagingWarehouses: map[api.PackType]string{"tar": "elsewhere"}},
+		} {
+			Wish(t, validateOutputs(cfg) != nil, ShouldEqual, true)
+		}
+	})
+}